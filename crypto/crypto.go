@@ -11,56 +11,99 @@ import (
 	"io"
 	"strings"
 
+	"golang.org/x/crypto/chacha20poly1305"
 	"golang.org/x/crypto/sha3"
 )
 
-func Encrypt(data []byte, keyBase64 log.RedactedString) (ciphertext []byte, err error) {
-	key, err := base64.StdEncoding.DecodeString(keyBase64.String())
-	if err != nil {
-		return
-	}
-	c, err := aes.NewCipher(key)
+// KeyShreddedError is returned when encrypting or decrypting with an empty key,
+// which is what key providers return for subjects whose key has been shredded.
+var KeyShreddedError = errors.New("encryption key is shredded")
+
+func GenKey() (key log.RedactedString, err error) {
+	b := make([]byte, 32)
+	_, err = rand.Read(b)
 	if err != nil {
 		return
 	}
-	gcm, err := cipher.NewGCM(c)
+	key = log.RedactedString(base64.StdEncoding.EncodeToString(b))
+	return
+}
+
+// Cipher is the AEAD used to seal data. The zero value is AES256GCM.
+type Cipher byte
+
+const (
+	AES256GCM Cipher = iota
+	ChaCha20Poly1305
+)
+
+var UnknownCipherError = errors.New("unknown cipher")
+
+func Encrypt(data []byte, keyBase64 log.RedactedString) (ciphertext []byte, err error) {
+	return EncryptAAD(AES256GCM, data, nil, keyBase64)
+}
+
+func Decrypt(ciphertextAndNounce []byte, keyBase64 log.RedactedString) (data []byte, err error) {
+	return DecryptAAD(AES256GCM, ciphertextAndNounce, nil, keyBase64)
+}
+
+// EncryptAAD seals data with the cipher, authenticating aad alongside it. The same aad has to be given to DecryptAAD,
+// which binds the ciphertext to whatever the aad describes. ChaCha20Poly1305 requires a 32 byte key.
+func EncryptAAD(c Cipher, data, aad []byte, keyBase64 log.RedactedString) (ciphertext []byte, err error) {
+	aead, err := newAEAD(c, keyBase64)
 	if err != nil {
 		return
 	}
 
-	nonce := make([]byte, gcm.NonceSize())
+	nonce := make([]byte, aead.NonceSize())
 	_, err = io.ReadFull(rand.Reader, nonce)
 	if err != nil {
 		return
 	}
 
-	ciphertext = gcm.Seal(nonce, nonce, data, nil)
+	ciphertext = aead.Seal(nonce, nonce, data, aad)
 	//baseText = base64.StdEncoding.EncodeToString(ciphertext)
 	return
 }
 
-func Decrypt(ciphertextAndNounce []byte, keyBase64 log.RedactedString) (data []byte, err error) {
-	key, err := base64.StdEncoding.DecodeString(keyBase64.String())
-	if err != nil {
-		return
-	}
-	c, err := aes.NewCipher(key)
-	if err != nil {
-		return
-	}
-	gcm, err := cipher.NewGCM(c)
+func DecryptAAD(c Cipher, ciphertextAndNounce, aad []byte, keyBase64 log.RedactedString) (data []byte, err error) {
+	aead, err := newAEAD(c, keyBase64)
 	if err != nil {
 		return
 	}
 
-	nonceSize := gcm.NonceSize()
+	nonceSize := aead.NonceSize()
 	if len(ciphertextAndNounce) < nonceSize {
 		err = errors.New("ciphertext size is less than nonceSize")
 		return
 	}
 
 	nonce, ciphertext := ciphertextAndNounce[:nonceSize], ciphertextAndNounce[nonceSize:]
-	data, err = gcm.Open(nil, nonce, ciphertext, nil)
+	data, err = aead.Open(nil, nonce, ciphertext, aad)
+	return
+}
+
+func newAEAD(c Cipher, keyBase64 log.RedactedString) (aead cipher.AEAD, err error) {
+	if keyBase64 == "" {
+		err = KeyShreddedError
+		return
+	}
+	key, err := base64.StdEncoding.DecodeString(keyBase64.String())
+	if err != nil {
+		return
+	}
+	switch c {
+	case AES256GCM:
+		var b cipher.Block
+		b, err = aes.NewCipher(key)
+		if err != nil {
+			return
+		}
+		return cipher.NewGCM(b)
+	case ChaCha20Poly1305:
+		return chacha20poly1305.New(key)
+	}
+	err = UnknownCipherError
 	return
 }
 
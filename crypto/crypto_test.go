@@ -2,10 +2,13 @@ package crypto
 
 import (
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"strings"
 	"testing"
 
+	log "github.com/cantara/bragi/sbragi"
+
 	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/scrypt"
 	"golang.org/x/crypto/sha3"
@@ -76,3 +79,115 @@ func BenchmarkCrypt(b *testing.B) {
 		Decrypt(d, "su1eev57I6SiQyImWLHB2Gbkkf1NebV5jvIahi6BhSw=")
 	}
 }
+
+func TestEncryptAAD(t *testing.T) {
+	key, err := GenKey()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, c := range []Cipher{AES256GCM, ChaCha20Poly1305} {
+		ciphertext, err := EncryptAAD(c, []byte("data"), []byte("event a"), key)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		data, err := DecryptAAD(c, ciphertext, []byte("event a"), key)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if string(data) != "data" {
+			t.Errorf("data missmatch %s", data)
+			return
+		}
+		_, err = DecryptAAD(c, ciphertext, []byte("event b"), key)
+		if err == nil {
+			t.Errorf("cipher %d decrypted with wrong associated data", c)
+			return
+		}
+	}
+}
+
+func TestEnvelopeCipher(t *testing.T) {
+	key, err := GenKey()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	envelope, err := EncryptEnvelopeAAD(ChaCha20Poly1305, []byte("data"), []byte("aad"), "", key)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	keyId, ok := EnvelopeKeyId(envelope)
+	if !ok || keyId != "" {
+		t.Errorf("key id missmatch %q", keyId)
+		return
+	}
+	if !EnvelopeHasAAD(envelope) {
+		t.Error("envelope not detected as having aad")
+		return
+	}
+	data, err := DecryptEnvelopeAAD(envelope, []byte("aad"), func(_ string) (log.RedactedString, error) {
+		return key, nil
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(data) != "data" {
+		t.Errorf("data missmatch %s", data)
+	}
+}
+
+func TestKeyHash(t *testing.T) {
+	if KeyHash("user@example.com") != SimpleHash("user@example.com") {
+		t.Error("key hash without secret should be simple hash")
+		return
+	}
+	secret, err := GenKey()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	restore, err := SwapKeyHashSecret(secret)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	t.Cleanup(restore)
+	hash := KeyHash("user@example.com")
+	expected, err := KeyedHash(secret, "user@example.com")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if hash != expected || !IsKeyedHash(hash) || IsKeyedHash(SimpleHash("user@example.com")) {
+		t.Errorf("keyed hash missmatch %s != %s", hash, expected)
+		return
+	}
+	other, err := GenKey()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	otherHash, err := KeyedHash(other, "user@example.com")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if otherHash == hash {
+		t.Error("keyed hash does not depend on the secret")
+		return
+	}
+	err = SetKeyHashSecret(log.RedactedString("c2hvcnQ="))
+	if !errors.Is(err, KeyHashSecretTooShortError) {
+		t.Errorf("expected too short secret error, got %v", err)
+		return
+	}
+	restore()
+	if KeyHash("user@example.com") != SimpleHash("user@example.com") {
+		t.Error("missmatch key hash after restoring no secret")
+	}
+}
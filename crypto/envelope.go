@@ -0,0 +1,113 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	log "github.com/cantara/bragi/sbragi"
+)
+
+// envelopeMagic prefixes the versioned ciphertext formats. Ciphertexts without it are the legacy nonce||ciphertext.
+var envelopeMagic = []byte{'g', 'b', 'r'}
+
+const (
+	// envelopeV1 is magic||1||len(keyId)||keyId||nonce||ciphertext, always AES256GCM.
+	envelopeV1 byte = 0x01
+	// envelopeV2 is magic||2||cipher||len(keyId)||keyId||nonce||ciphertext, where the key id may be empty.
+	envelopeV2 byte = 0x02
+)
+
+var InvalidKeyIdError = errors.New("key id must be between 1 and 255 bytes")
+var InvalidEnvelopeError = errors.New("ciphertext envelope is invalid")
+
+// EncryptEnvelope encrypts data with AES256GCM into an envelope that records keyId,
+// so that the key used can be found when decrypting after the key has been rotated.
+func EncryptEnvelope(data []byte, keyId string, keyBase64 log.RedactedString) (envelope []byte, err error) {
+	return EncryptEnvelopeAAD(AES256GCM, data, nil, keyId, keyBase64)
+}
+
+// EncryptEnvelopeAAD encrypts data with the cipher and aad into an envelope that records the cipher and keyId.
+// An empty keyId marks a key that is not from a key ring.
+func EncryptEnvelopeAAD(c Cipher, data, aad []byte, keyId string, keyBase64 log.RedactedString) (envelope []byte, err error) {
+	if len(keyId) > 255 {
+		err = InvalidKeyIdError
+		return
+	}
+	ciphertext, err := EncryptAAD(c, data, aad, keyBase64)
+	if err != nil {
+		return
+	}
+	envelope = make([]byte, 0, len(envelopeMagic)+3+len(keyId)+len(ciphertext))
+	envelope = append(envelope, envelopeMagic...)
+	envelope = append(envelope, envelopeV2, byte(c), byte(len(keyId)))
+	envelope = append(envelope, keyId...)
+	envelope = append(envelope, ciphertext...)
+	return
+}
+
+// EnvelopeKeyId returns the key id of an envelope, ok is false for legacy ciphertexts.
+func EnvelopeKeyId(ciphertext []byte) (keyId string, ok bool) {
+	_, keyId, _, ok = openEnvelope(ciphertext)
+	return
+}
+
+// EnvelopeHasAAD reports if the ciphertext is an envelope that is encrypted with aad, false for legacy ciphertexts and
+// version 1 envelopes.
+func EnvelopeHasAAD(ciphertext []byte) bool {
+	_, _, _, ok := openEnvelope(ciphertext)
+	return ok && ciphertext[len(envelopeMagic)] != envelopeV1
+}
+
+// DecryptEnvelope decrypts an envelope with the key returned for its key id.
+func DecryptEnvelope(envelope []byte, key func(keyId string) (log.RedactedString, error)) (data []byte, err error) {
+	return DecryptEnvelopeAAD(envelope, nil, key)
+}
+
+// DecryptEnvelopeAAD decrypts an envelope with the aad it was encrypted with and the key returned for its key id.
+// Version 1 envelopes carry no aad, so aad is ignored for them.
+func DecryptEnvelopeAAD(envelope, aad []byte, key func(keyId string) (log.RedactedString, error)) (data []byte, err error) {
+	c, keyId, ciphertext, ok := openEnvelope(envelope)
+	if !ok {
+		err = InvalidEnvelopeError
+		return
+	}
+	if envelope[len(envelopeMagic)] == envelopeV1 {
+		aad = nil
+	}
+	k, err := key(keyId)
+	if err != nil {
+		err = fmt.Errorf("key id %s, error:%w", keyId, err)
+		return
+	}
+	return DecryptAAD(c, ciphertext, aad, k)
+}
+
+func openEnvelope(envelope []byte) (c Cipher, keyId string, ciphertext []byte, ok bool) {
+	if !bytes.HasPrefix(envelope, envelopeMagic) || len(envelope) < len(envelopeMagic)+2 {
+		return
+	}
+	start := len(envelopeMagic) + 1
+	switch envelope[len(envelopeMagic)] {
+	case envelopeV1:
+		c = AES256GCM
+	case envelopeV2:
+		c = Cipher(envelope[start])
+		start++
+	default:
+		return
+	}
+	if len(envelope) < start+1 {
+		return
+	}
+	l := int(envelope[start])
+	start++
+	if len(envelope) < start+l {
+		return
+	}
+	keyId = string(envelope[start : start+l])
+	if keyId == "" && envelope[len(envelopeMagic)] == envelopeV1 {
+		return
+	}
+	return c, keyId, envelope[start+l:], true
+}
@@ -0,0 +1,87 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"sync/atomic"
+
+	log "github.com/cantara/bragi/sbragi"
+	"golang.org/x/crypto/sha3"
+)
+
+// keyedHashPrefix marks keyed hashes, it is not in the alphabet of SimpleHash so the two can not be confused.
+const keyedHashPrefix = "hmac."
+
+var KeyHashSecretTooShortError = errors.New("key hash secret must be at least 32 bytes")
+
+var keyHashSecret atomic.Pointer[[]byte]
+
+// SetKeyHashSecret sets the per-deployment secret KeyHash uses. All instances writing to the same streams have to use
+// the same secret, and it has to be kept for as long as the streams are, as it can not be recovered from the hashes.
+func SetKeyHashSecret(secretBase64 log.RedactedString) (err error) {
+	secret, err := base64.StdEncoding.DecodeString(secretBase64.String())
+	if err != nil {
+		return
+	}
+	if len(secret) < 32 {
+		err = KeyHashSecretTooShortError
+		return
+	}
+	keyHashSecret.Store(&secret)
+	return
+}
+
+// SwapKeyHashSecret sets the secret like SetKeyHashSecret and returns a function that restores the secret that was set
+// before, or no secret, so tests and migrations can use a secret for a while without changing it for the process.
+func SwapKeyHashSecret(secretBase64 log.RedactedString) (restore func(), err error) {
+	previous := keyHashSecret.Load()
+	err = SetKeyHashSecret(secretBase64)
+	if err != nil {
+		return
+	}
+	restore = func() {
+		keyHashSecret.Store(previous)
+	}
+	return
+}
+
+// KeyHash hashes a key for use as event metadata key. With a secret set it is KeyedHash, so that guessable keys like
+// emails or user ids can not be recovered from the stream. Without a secret it falls back to the unkeyed SimpleHash.
+//
+// Events written before a secret was set keep their SimpleHash keys, and since keys are part of what the data is
+// encrypted against they are not rewritten in place. Use consumer.RehashKeys, or the RehashKeys of the event maps and
+// tasks, to copy a stream to one with keyed hashes.
+func KeyHash(key string) string {
+	secret := keyHashSecret.Load()
+	if secret == nil {
+		return SimpleHash(key)
+	}
+	return keyedHash(*secret, key)
+}
+
+// KeyedHash is the HMAC-SHA3-512 of key with the secret, prefixed so it can be told apart from a SimpleHash.
+func KeyedHash(secretBase64 log.RedactedString, key string) (out string, err error) {
+	secret, err := base64.StdEncoding.DecodeString(secretBase64.String())
+	if err != nil {
+		return
+	}
+	if len(secret) < 32 {
+		err = KeyHashSecretTooShortError
+		return
+	}
+	out = keyedHash(secret, key)
+	return
+}
+
+// IsKeyedHash reports if the metadata key is a keyed hash rather than a SimpleHash or an unhashed key.
+func IsKeyedHash(hash string) bool {
+	return strings.HasPrefix(hash, keyedHashPrefix)
+}
+
+func keyedHash(secret []byte, key string) string {
+	mac := hmac.New(sha3.New512, secret)
+	mac.Write([]byte(key))
+	return keyedHashPrefix + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
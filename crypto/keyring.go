@@ -0,0 +1,238 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	log "github.com/cantara/bragi/sbragi"
+	jsoniter "github.com/json-iterator/go"
+)
+
+var json = jsoniter.ConfigDefault
+
+// KeyRing holds several keys per subject, identified by key id. New data is encrypted with the current key,
+// while old data can still be decrypted with any key that is in the ring.
+type KeyRing interface {
+	Current(subject string) (keyId string, key log.RedactedString, err error)
+	Key(subject, keyId string) (key log.RedactedString, err error)
+}
+
+var NoCurrentKeyError = errors.New("subject has no current key")
+var UnknownKeyIdError = errors.New("key id is not in the key ring")
+
+// DefaultSubject is used for subjects that have no keys of their own.
+const DefaultSubject = ""
+
+type subjectKeys struct {
+	current string
+	keys    map[string]log.RedactedString
+}
+
+// LocalKeyRing is a KeyRing in process memory, optionally persisted to a file encrypted with a master key.
+type LocalKeyRing struct {
+	subjects  map[string]*subjectKeys
+	path      string
+	masterKey log.RedactedString
+	lock      sync.RWMutex
+}
+
+// NewKeyRing returns a key ring that only lives in memory, keys added to or rotated into it are lost when the process
+// stops, so they have to be kept elsewhere to decrypt data written with them after a restart.
+func NewKeyRing() *LocalKeyRing {
+	return &LocalKeyRing{
+		subjects: make(map[string]*subjectKeys),
+	}
+}
+
+// storedSubject is the persisted form of the keys of a subject.
+type storedSubject struct {
+	Current string            `json:"current"`
+	Keys    map[string]string `json:"keys"`
+}
+
+// NewPersistentKeyRing returns a key ring that is persisted to the file at path, encrypted with the master key like
+// keystore.Local stores its keys. The ring in the file is loaded if there is one. Every change is written to the file
+// before it takes effect, so data is never encrypted with a key that is lost on a restart.
+func NewPersistentKeyRing(path string, masterKey log.RedactedString) (r *LocalKeyRing, err error) {
+	r = &LocalKeyRing{
+		subjects:  make(map[string]*subjectKeys),
+		path:      path,
+		masterKey: masterKey,
+	}
+	stored, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		return
+	}
+	data, err := Decrypt(stored, masterKey)
+	if err != nil {
+		err = fmt.Errorf("decrypting key ring %s, error:%w", path, err)
+		return
+	}
+	var subjects map[string]storedSubject
+	err = json.Unmarshal(data, &subjects)
+	if err != nil {
+		return
+	}
+	for subject, ss := range subjects {
+		sk := &subjectKeys{
+			current: ss.Current,
+			keys:    make(map[string]log.RedactedString, len(ss.Keys)),
+		}
+		for keyId, key := range ss.Keys {
+			sk.keys[keyId] = log.RedactedString(key)
+		}
+		r.subjects[subject] = sk
+	}
+	return
+}
+
+// save writes the ring to its file, if it has one, through a temporary file so a crash never leaves a partly written
+// ring. It is called with the lock held.
+func (r *LocalKeyRing) save() (err error) {
+	if r.path == "" {
+		return
+	}
+	subjects := make(map[string]storedSubject, len(r.subjects))
+	for subject, sk := range r.subjects {
+		ss := storedSubject{
+			Current: sk.current,
+			Keys:    make(map[string]string, len(sk.keys)),
+		}
+		for keyId, key := range sk.keys {
+			ss.Keys[keyId] = key.String()
+		}
+		subjects[subject] = ss
+	}
+	data, err := json.Marshal(subjects)
+	if err != nil {
+		return
+	}
+	stored, err := Encrypt(data, r.masterKey)
+	if err != nil {
+		return
+	}
+	f, err := os.OpenFile(r.path+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return
+	}
+	_, err = f.Write(stored)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		f.Close()
+		return
+	}
+	err = f.Close()
+	if err != nil {
+		return
+	}
+	return os.Rename(r.path+".tmp", r.path)
+}
+
+// Add adds a key to the subject, making it the current key if current is set or it is the first key of the subject.
+func (r *LocalKeyRing) Add(subject, keyId string, key log.RedactedString, current bool) (err error) {
+	if len(keyId) == 0 || len(keyId) > 255 {
+		return InvalidKeyIdError
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	sk, known := r.subjects[subject]
+	if !known {
+		sk = &subjectKeys{
+			keys: make(map[string]log.RedactedString),
+		}
+		r.subjects[subject] = sk
+	}
+	previousKey, had := sk.keys[keyId]
+	previousCurrent := sk.current
+	sk.keys[keyId] = key
+	if current || sk.current == "" {
+		sk.current = keyId
+	}
+	err = r.save()
+	if err != nil {
+		if had {
+			sk.keys[keyId] = previousKey
+		} else {
+			delete(sk.keys, keyId)
+		}
+		sk.current = previousCurrent
+		if !known {
+			delete(r.subjects, subject)
+		}
+	}
+	return
+}
+
+// Rotate generates a new current key for the subject, keeping the old keys for decryption. The new key is only kept
+// after a restart by rings from NewPersistentKeyRing.
+func (r *LocalKeyRing) Rotate(subject string) (keyId string, err error) {
+	key, err := GenKey()
+	if err != nil {
+		return
+	}
+	keyId = GenRandBase32String(16)
+	err = r.Add(subject, keyId, key, true)
+	return
+}
+
+// Remove removes a key that is no longer used by any data. The current key can not be removed.
+func (r *LocalKeyRing) Remove(subject, keyId string) (err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	sk, ok := r.subjects[subject]
+	if !ok {
+		return
+	}
+	if sk.current == keyId {
+		return fmt.Errorf("key id %s is the current key of the subject", keyId)
+	}
+	key, had := sk.keys[keyId]
+	if !had {
+		return
+	}
+	delete(sk.keys, keyId)
+	err = r.save()
+	if err != nil {
+		sk.keys[keyId] = key
+	}
+	return
+}
+
+func (r *LocalKeyRing) subject(subject string) (sk *subjectKeys, ok bool) {
+	sk, ok = r.subjects[subject]
+	if !ok {
+		sk, ok = r.subjects[DefaultSubject]
+	}
+	return
+}
+
+func (r *LocalKeyRing) Current(subject string) (keyId string, key log.RedactedString, err error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	sk, ok := r.subject(subject)
+	if !ok || sk.current == "" {
+		err = NoCurrentKeyError
+		return
+	}
+	return sk.current, sk.keys[sk.current], nil
+}
+
+func (r *LocalKeyRing) Key(subject, keyId string) (key log.RedactedString, err error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	sk, ok := r.subject(subject)
+	if ok {
+		key, ok = sk.keys[keyId]
+	}
+	if !ok {
+		err = UnknownKeyIdError
+	}
+	return
+}
@@ -0,0 +1,170 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	log "github.com/cantara/bragi/sbragi"
+)
+
+func TestEnvelopeRotation(t *testing.T) {
+	ring := NewKeyRing()
+	oldId, err := ring.Rotate(DefaultSubject)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, oldKey, err := ring.Current("any")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ct, err := EncryptEnvelope([]byte("secret"), oldId, oldKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	keyId, ok := EnvelopeKeyId(ct)
+	if !ok || keyId != oldId {
+		t.Errorf("key id missmatch %s != %s", keyId, oldId)
+		return
+	}
+	newId, err := ring.Rotate(DefaultSubject)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	currentId, _, err := ring.Current("any")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if currentId != newId {
+		t.Errorf("current key id missmatch %s != %s", currentId, newId)
+		return
+	}
+	data, err := DecryptEnvelope(ct, func(keyId string) (log.RedactedString, error) {
+		return ring.Key("any", keyId)
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !bytes.Equal(data, []byte("secret")) {
+		t.Errorf("data missmatch %s", data)
+		return
+	}
+	err = ring.Remove(DefaultSubject, oldId)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = DecryptEnvelope(ct, func(keyId string) (log.RedactedString, error) {
+		return ring.Key("any", keyId)
+	})
+	if !errors.Is(err, UnknownKeyIdError) {
+		t.Errorf("expected unknown key id error, got %v", err)
+	}
+}
+
+func TestLegacyCiphertextIsNotEnvelope(t *testing.T) {
+	key, err := GenKey()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ct, err := Encrypt([]byte("secret"), key)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if _, ok := EnvelopeKeyId(ct); ok {
+		t.Error("legacy ciphertext detected as envelope")
+	}
+	if EnvelopeHasAAD(ct) {
+		t.Error("legacy ciphertext detected as having aad")
+	}
+}
+
+func TestPersistentKeyRing(t *testing.T) {
+	masterKey, err := GenKey()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	path := filepath.Join(t.TempDir(), "ring")
+	ring, err := NewPersistentKeyRing(path, masterKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	oldId, err := ring.Rotate("subject")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	newId, err := ring.Rotate("subject")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	oldKey, err := ring.Key("subject", oldId)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, newKey, err := ring.Current("subject")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ring, err = NewPersistentKeyRing(path, masterKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	currentId, key, err := ring.Current("subject")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if currentId != newId || key != newKey {
+		t.Errorf("current key missmatch after reopening, %s != %s", currentId, newId)
+		return
+	}
+	key, err = ring.Key("subject", oldId)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if key != oldKey {
+		t.Error("old key missmatch after reopening")
+		return
+	}
+	err = ring.Remove("subject", oldId)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ring, err = NewPersistentKeyRing(path, masterKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = ring.Key("subject", oldId)
+	if !errors.Is(err, UnknownKeyIdError) {
+		t.Errorf("expected unknown key id error for removed key, got %v", err)
+		return
+	}
+	otherKey, err := GenKey()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = NewPersistentKeyRing(path, otherKey)
+	if err == nil {
+		t.Error("key ring opened with another master key")
+	}
+}
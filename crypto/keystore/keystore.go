@@ -0,0 +1,188 @@
+package keystore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	log "github.com/cantara/bragi/sbragi"
+	"github.com/cantara/gober/crypto"
+)
+
+// KeyStore holds a distinct encryption key per subject, typically the event metadata key.
+// Shredding a subject deletes its key so that all data encrypted with it becomes unreadable.
+// Key is used when writing and creates the key of new subjects, Lookup is used when reading and never creates keys.
+type KeyStore interface {
+	Key(subject string) (key log.RedactedString, err error)
+	Lookup(subject string) (key log.RedactedString, err error)
+	Shred(subject string) (err error)
+	Shredded(subject string) bool
+	Provider() func(subject string) log.RedactedString
+	LookupProvider() func(subject string) log.RedactedString
+}
+
+var SubjectShreddedError = fmt.Errorf("subject key is shredded, error:%w", crypto.KeyShreddedError)
+var UnknownSubjectError = errors.New("subject has no key")
+
+var tombstone = []byte("shredded")
+
+// Local is a KeyStore that keeps one file per subject in a directory. The subject keys are stored encrypted with the
+// master key, and shredded subjects are overwritten and replaced with a tombstone so a new key is never generated for them.
+type Local struct {
+	dir       string
+	masterKey log.RedactedString
+	keys      map[string]log.RedactedString
+	lock      sync.RWMutex
+}
+
+func NewLocal(dir string, masterKey log.RedactedString) (ks *Local, err error) {
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return
+	}
+	ks = &Local{
+		dir:       dir,
+		masterKey: masterKey,
+		keys:      make(map[string]log.RedactedString),
+	}
+	return
+}
+
+func (ks *Local) path(subject string) string {
+	return filepath.Join(ks.dir, crypto.SimpleHash(subject))
+}
+
+// Key returns the key of the subject, generating and persisting a new one the first time the subject is seen.
+// It is meant for writing, use Lookup when reading.
+func (ks *Local) Key(subject string) (key log.RedactedString, err error) {
+	return ks.key(subject, true)
+}
+
+// Lookup returns the key of the subject without ever creating one, failing with SubjectShreddedError for shredded
+// subjects and UnknownSubjectError for subjects without a key. Reads use it, so reading data of a subject that is not in
+// the store neither writes to disk nor gives the subject a key.
+func (ks *Local) Lookup(subject string) (key log.RedactedString, err error) {
+	return ks.key(subject, false)
+}
+
+func (ks *Local) key(subject string, create bool) (key log.RedactedString, err error) {
+	ks.lock.RLock()
+	key, ok := ks.keys[subject]
+	ks.lock.RUnlock()
+	if ok {
+		return
+	}
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+	if key, ok = ks.keys[subject]; ok {
+		return
+	}
+	p := ks.path(subject)
+	stored, err := os.ReadFile(p)
+	if err == nil {
+		if bytes.Equal(stored, tombstone) {
+			err = SubjectShreddedError
+			return
+		}
+		var k []byte
+		k, err = crypto.Decrypt(stored, ks.masterKey)
+		if err != nil {
+			return
+		}
+		key = log.RedactedString(k)
+		ks.keys[subject] = key
+		return
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if !create {
+		err = UnknownSubjectError
+		return
+	}
+	key, err = crypto.GenKey()
+	if err != nil {
+		return
+	}
+	stored, err = crypto.Encrypt([]byte(key.String()), ks.masterKey)
+	if err != nil {
+		return
+	}
+	err = writeSync(p, stored)
+	if err != nil {
+		return
+	}
+	ks.keys[subject] = key
+	return
+}
+
+// Shred irreversibly deletes the key of the subject. Shredding an unknown subject still leaves a tombstone.
+func (ks *Local) Shred(subject string) (err error) {
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+	delete(ks.keys, subject)
+	p := ks.path(subject)
+	stored, err := os.ReadFile(p)
+	if err == nil && !bytes.Equal(stored, tombstone) {
+		err = writeSync(p, make([]byte, len(stored)))
+		if err != nil {
+			return
+		}
+	}
+	return writeSync(p, tombstone)
+}
+
+func (ks *Local) Shredded(subject string) bool {
+	stored, err := os.ReadFile(ks.path(subject))
+	return err == nil && bytes.Equal(stored, tombstone)
+}
+
+// unavailableKey is not valid base64, so other failures surface as decryption errors rather than as shredded subjects.
+const unavailableKey log.RedactedString = "unavailable"
+
+// Provider adapts the key store to a stream.CryptoKeyProvider for writing, creating the keys of new subjects.
+// Shredded subjects get an empty key.
+func (ks *Local) Provider() func(subject string) log.RedactedString {
+	return provider(ks.Key)
+}
+
+// LookupProvider adapts the key store to a stream.CryptoKeyProvider for reading, give it to consumers with
+// stream.WithDecryptKey. Shredded subjects get an empty key, and subjects without a key one that fails to decrypt.
+func (ks *Local) LookupProvider() func(subject string) log.RedactedString {
+	return provider(ks.Lookup)
+}
+
+func provider(get func(subject string) (log.RedactedString, error)) func(subject string) log.RedactedString {
+	return func(subject string) log.RedactedString {
+		key, err := get(subject)
+		if err != nil {
+			if errors.Is(err, SubjectShreddedError) {
+				return ""
+			}
+			log.WithError(err).Error("while getting subject key")
+			return unavailableKey
+		}
+		return key
+	}
+}
+
+func writeSync(p string, data []byte) (err error) {
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return
+	}
+	_, err = f.Write(data)
+	if err != nil {
+		f.Close()
+		return
+	}
+	err = f.Sync()
+	if err != nil {
+		f.Close()
+		return
+	}
+	return f.Close()
+}
@@ -0,0 +1,155 @@
+package keystore
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	log "github.com/cantara/bragi/sbragi"
+	"github.com/cantara/gober/crypto"
+)
+
+var testMasterKey = log.RedactedString("aPSIX6K3yw6cAWDQHGPjmhuOswuRibjyLLnd91ojdK0=")
+
+func TestKeyPerSubject(t *testing.T) {
+	dir := t.TempDir()
+	ks, err := NewLocal(dir, testMasterKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	a, err := ks.Key("a")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	b, err := ks.Key("b")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if a == b {
+		t.Error("subjects should have distinct keys")
+		return
+	}
+	reopened, err := NewLocal(dir, testMasterKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	again, err := reopened.Key("a")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if again != a {
+		t.Error("subject key was not persisted")
+		return
+	}
+	ciphertext, err := crypto.Encrypt([]byte("data"), ks.Provider()("a"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = crypto.Decrypt(ciphertext, reopened.Provider()("a"))
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestShred(t *testing.T) {
+	dir := t.TempDir()
+	ks, err := NewLocal(dir, testMasterKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	key, err := ks.Key("subject")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ciphertext, err := crypto.Encrypt([]byte("data"), key)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = ks.Shred("subject")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !ks.Shredded("subject") {
+		t.Error("subject should be shredded")
+		return
+	}
+	reopened, err := NewLocal(dir, testMasterKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = reopened.Key("subject")
+	if !errors.Is(err, SubjectShreddedError) {
+		t.Errorf("expected subject shredded error, got %v", err)
+		return
+	}
+	_, err = crypto.Decrypt(ciphertext, reopened.Provider()("subject"))
+	if !errors.Is(err, crypto.KeyShreddedError) {
+		t.Errorf("expected key shredded error, got %v", err)
+		return
+	}
+	_, err = reopened.Lookup("subject")
+	if !errors.Is(err, SubjectShreddedError) {
+		t.Errorf("expected subject shredded error on lookup, got %v", err)
+		return
+	}
+	_, err = crypto.Decrypt(ciphertext, reopened.LookupProvider()("subject"))
+	if !errors.Is(err, crypto.KeyShreddedError) {
+		t.Errorf("expected key shredded error from lookup provider, got %v", err)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	dir := t.TempDir()
+	ks, err := NewLocal(dir, testMasterKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = ks.Lookup("unknown")
+	if !errors.Is(err, UnknownSubjectError) {
+		t.Errorf("expected unknown subject error, got %v", err)
+		return
+	}
+	if ks.LookupProvider()("unknown") == "" {
+		t.Error("unknown subject looked up as shredded")
+		return
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(files) != 0 {
+		t.Errorf("missmatch, lookup wrote %d key files", len(files))
+		return
+	}
+	key, err := ks.Key("known")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	reopened, err := NewLocal(dir, testMasterKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	looked, err := reopened.Lookup("known")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if looked != key {
+		t.Error("missmatch key looked up after reopening")
+	}
+}
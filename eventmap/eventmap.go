@@ -21,8 +21,9 @@ type EventMap[DT any] interface {
 	Keys() (keys []string)
 	Range(f func(key string, value DT) bool)
 	GetMap() map[string]DT
-	Delete(key string) (err error)
-	Set(key string, data DT) (err error)
+	Delete(key string) (position uint64, err error)
+	Set(key string, data DT) (position uint64, err error)
+	WaitForPosition(ctx context.Context, pos uint64) (err error)
 	Stream(eventTypes []event.Type, from store.StreamPosition, filter stream.Filter, ctx context.Context) (out <-chan event.Event[DT], err error)
 }
 
@@ -31,7 +32,7 @@ type mapData[DT any] struct {
 	eventTypeName    string
 	eventTypeVersion string
 	provider         stream.CryptoKeyProvider
-	es               consumer.Consumer[kv[DT]]
+	es               consumer.SelectedConsumer[kv[DT]]
 }
 
 type kv[DT any] struct {
@@ -63,6 +64,9 @@ func Init[DT any](pers stream.Stream, eventType, dataTypeVersion string, p strea
 			case e := <-readChan:
 				func() {
 					defer e.Acc()
+					if e.Shredded {
+						return
+					}
 					if e.Type == event.Deleted {
 						m.data.Delete(e.Data.Key)
 						return
@@ -77,6 +81,13 @@ func Init[DT any](pers stream.Stream, eventType, dataTypeVersion string, p strea
 	return
 }
 
+// RehashKeys copies an event map stream to dst with metadata keys hashed by crypto.KeyHash, see consumer.RehashKeys.
+func RehashKeys[DT any](src, dst stream.Stream, p stream.CryptoKeyProvider, ctx context.Context) (n int, err error) {
+	return consumer.RehashKeys[kv[DT]](src, dst, p, func(data kv[DT]) string {
+		return data.Key
+	}, ctx)
+}
+
 func (m *mapData[DT]) Stream(eventTypes []event.Type, from store.StreamPosition, filter stream.Filter, ctx context.Context) (out <-chan event.Event[DT], err error) {
 	s, err := m.es.Stream(eventTypes, from, filter, ctx)
 	if err != nil {
@@ -165,13 +176,14 @@ func (m *mapData[DT]) createEvent(key string, data DT) (e event.Event[kv[DT]], e
 		Metadata: event.Metadata{
 			Version:  m.eventTypeVersion,
 			DataType: m.eventTypeName,
-			Key:      crypto.SimpleHash(key),
+			Key:      crypto.KeyHash(key),
 		},
 	}
 	return
 }
 
-func (m *mapData[DT]) Delete(key string) (err error) {
+// Delete writes a delete of key and returns its position once the map has applied it.
+func (m *mapData[DT]) Delete(key string) (position uint64, err error) {
 	e := event.Event[kv[DT]]{
 		Type: event.Deleted,
 		Data: kv[DT]{
@@ -180,16 +192,18 @@ func (m *mapData[DT]) Delete(key string) (err error) {
 		Metadata: event.Metadata{
 			Version:  m.eventTypeVersion,
 			DataType: m.eventTypeName,
-			Key:      crypto.SimpleHash(key),
+			Key:      crypto.KeyHash(key),
 		},
 	}
 	we := event.NewWriteEvent(e)
 	m.es.Write() <- we
-	<-we.Done() //Missing error
-	return
+	status := <-we.Done()
+	return status.Position, status.Error
 }
 
-func (m *mapData[DT]) Set(key string, data DT) (err error) {
+// Set writes the value of key and returns its position once the map has applied it. Other maps of the same stream
+// can wait for the position with WaitForPosition before reading.
+func (m *mapData[DT]) Set(key string, data DT) (position uint64, err error) {
 	log.Trace("Set and wait start", "key", key)
 	e, err := m.createEvent(key, data)
 	if err != nil {
@@ -197,7 +211,12 @@ func (m *mapData[DT]) Set(key string, data DT) (err error) {
 	}
 	we := event.NewWriteEvent(e)
 	m.es.Write() <- we
-	<-we.Done() //Missing error
-	log.Trace("Set and wait end", "key", key)
-	return
+	status := <-we.Done()
+	log.Trace("Set and wait end", "key", key, "position", status.Position)
+	return status.Position, status.Error
+}
+
+// WaitForPosition waits until the map has applied the events up to pos, or ctx is done.
+func (m *mapData[DT]) WaitForPosition(ctx context.Context, pos uint64) (err error) {
+	return m.es.WaitForPosition(ctx, pos)
 }
@@ -2,10 +2,17 @@ package eventmap
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	log "github.com/cantara/bragi/sbragi"
+	"github.com/cantara/gober/crypto"
+	"github.com/cantara/gober/stream"
+	"github.com/cantara/gober/stream/event"
+	"github.com/cantara/gober/stream/event/store"
+	"github.com/cantara/gober/stream/event/store/inmemory"
 	"github.com/cantara/gober/stream/event/store/ondisk"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -47,11 +54,14 @@ func TestStore(t *testing.T) {
 		Id:   1,
 		Name: "test",
 	}
-	err := ed.Set("1_test", data)
+	pos, err := ed.Set("1_test", data)
 	if err != nil {
 		t.Error(err)
 		return
 	}
+	if pos == 0 {
+		t.Error(fmt.Errorf("missmatch set position, %d == 0", pos))
+	}
 	return
 }
 
@@ -72,6 +82,132 @@ func TestGet(t *testing.T) {
 	}
 }
 
+func TestWaitForPosition(t *testing.T) {
+	ctx, cancel := context.WithCancel(ctxGlobal)
+	defer cancel()
+	pers, err := inmemory.Init(STREAM_NAME+"_wait", ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	writer, err := Init[dd](pers, "wait", "1.0.0", cryptKeyProvider, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	reader, err := Init[dd](pers, "wait", "1.0.0", cryptKeyProvider, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	pos, err := writer.Set("wait", dd{Id: 1, Name: "wait"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = reader.WaitForPosition(ctx, pos)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	data, err := reader.Get("wait")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if data.Name != "wait" {
+		t.Error(fmt.Errorf("missmatch data name after waiting, %s != wait", data.Name))
+	}
+	pos, err = writer.Delete("wait")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = reader.WaitForPosition(ctx, pos)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if reader.Exists("wait") {
+		t.Error(fmt.Errorf("missmatch, deleted key exists after waiting"))
+	}
+	wctx, wcancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer wcancel()
+	err = reader.WaitForPosition(wctx, pos+1)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Error(fmt.Errorf("missmatch error waiting for a position not written, %v", err))
+	}
+}
+
+func TestRehashKeys(t *testing.T) {
+	ctx, cancel := context.WithCancel(ctxGlobal)
+	defer cancel()
+	src, err := inmemory.Init(STREAM_NAME+"_rehash_src", ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	m, err := Init[dd](src, "rehash", "1.0.0", cryptKeyProvider, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = m.Set("user@example.com", dd{Id: 1, Name: "rehash"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	secret := log.RedactedString("c2VjcmV0X2Zvcl90ZXN0aW5nX2tleWVkX2hhc2hpbmdfb2Zfa2V5cw==")
+	restore, err := crypto.SwapKeyHashSecret(secret)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	t.Cleanup(restore)
+	dst, err := inmemory.Init(STREAM_NAME+"_rehash_dst", ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	n, err := RehashKeys[dd](src, dst, cryptKeyProvider, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if n != 1 {
+		t.Error(fmt.Errorf("missmatch rehashed events, %d != 1", n))
+		return
+	}
+	s, err := m.Stream(event.AllTypes(), store.STREAM_START, stream.ReadAll(), ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if e := <-s; e.Metadata.Key != crypto.SimpleHash("user@example.com") {
+		t.Error(fmt.Errorf("missmatch source key, %s", e.Metadata.Key))
+		return
+	}
+	rehashed, err := Init[dd](dst, "rehash", "1.0.0", cryptKeyProvider, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s, err = rehashed.Stream(event.AllTypes(), store.STREAM_START, stream.ReadAll(), ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected, err := crypto.KeyedHash(secret, "user@example.com")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	e := <-s
+	if e.Metadata.Key != expected || e.Data.Name != "rehash" {
+		t.Error(fmt.Errorf("missmatch rehashed event, %v", e))
+	}
+}
+
 func TestTairdown(t *testing.T) {
 	ctxGlobalCancel()
 }
@@ -170,7 +170,7 @@ func Init[DT, MT any](serv webserver.Server, s stream.Stream, dataTypeName, data
 	}
 	m.es = es
 
-	eventChan, err := es.Stream(event.AllTypes(), from, stream.ReadDataType(dataTypeName), ctx)
+	eventChan, err := es.StreamSelected(stream.SelectDataTypes(dataTypeName), from, nil, ctx)
 	if err != nil {
 		return
 	}
@@ -183,6 +183,9 @@ func Init[DT, MT any](serv webserver.Server, s stream.Stream, dataTypeName, data
 			case e := <-eventChan:
 				func() {
 					defer e.Acc()
+					if e.Shredded {
+						return
+					}
 					if e.Type == event.Deleted {
 						m.delete(e.ReadEvent)
 						return
@@ -226,7 +229,7 @@ func (m *mapData[DT, MT]) create(e event.ReadEvent[discoveryMetadata[MT]]) {
 			Metadata: event.Metadata{
 				Version:  m.dataTypeVersion,
 				DataType: m.dataTypeName,
-				Key:      crypto.SimpleHash(dmd.Meta.NewId.String()),
+				Key:      crypto.KeyHash(dmd.Meta.NewId.String()),
 				Extra:    map[string]any{"instance": m.instance},
 			},
 		}
@@ -266,7 +269,7 @@ func (m *mapData[DT, MT]) create(e event.ReadEvent[discoveryMetadata[MT]]) {
 				Metadata: event.Metadata{
 					Version:  m.dataTypeVersion,
 					DataType: m.dataTypeName,
-					Key:      crypto.SimpleHash(dmd.Meta.NewId.String()),
+					Key:      crypto.KeyHash(dmd.Meta.NewId.String()),
 					Extra:    map[string]any{"instance": m.instance},
 				},
 			}
@@ -474,7 +477,7 @@ func (m *mapData[DT, MT]) Delete(data MT) (err error) {
 		Metadata: event.Metadata{
 			Version:  m.dataTypeVersion,
 			DataType: m.dataTypeName,
-			Key:      crypto.SimpleHash(md.NewId.String()),
+			Key:      crypto.KeyHash(md.NewId.String()),
 		},
 	}
 
@@ -537,7 +540,7 @@ func (m *mapData[DT, MT]) Set(data DT, meta MT) (err error) {
 		Metadata: event.Metadata{
 			Version:  m.dataTypeVersion,
 			DataType: m.dataTypeName,
-			Key:      crypto.SimpleHash(md.NewId.String()),
+			Key:      crypto.KeyHash(md.NewId.String()),
 			Extra:    map[string]any{"instance": m.instance},
 		},
 	}
@@ -26,8 +26,9 @@ type EventMap[DT any] interface {
 	Len() (l int)
 	Keys() (keys []string)
 	Range(f func(key string, data DT) error)
-	Delete(data DT) (err error)
-	Set(data DT) (err error)
+	Delete(data DT) (position uint64, err error)
+	Set(data DT) (position uint64, err error)
+	WaitForPosition(ctx context.Context, pos uint64) (err error)
 	Stream(eventTypes []event.Type, from store.StreamPosition, filter stream.Filter, ctx context.Context) (out <-chan event.Event[DT], err error)
 }
 
@@ -40,7 +41,8 @@ type mapData[DT any] struct {
 	dataTypeName    string
 	dataTypeVersion string
 	provider        stream.CryptoKeyProvider
-	es              consumer.Consumer[DT]
+	es              consumer.SelectedConsumer[DT]
+	from            uint64
 	ctx             context.Context
 	getKey          func(dt DT) string
 }
@@ -84,9 +86,10 @@ func Init[DT any](s stream.Stream, dataTypeName, dataTypeVersion string, p strea
 		dataTypeVersion: dataTypeVersion,
 		provider:        p,
 		es:              es,
+		from:            uint64(from),
 		getKey:          getKey,
 	}
-	eventChan, err := es.Stream(event.AllTypes(), from, stream.ReadDataType(dataTypeName), ctx)
+	eventChan, err := es.StreamSelected(stream.SelectDataTypes(dataTypeName), from, nil, ctx)
 	if err != nil {
 		return
 	}
@@ -96,6 +99,10 @@ func Init[DT any](s stream.Stream, dataTypeName, dataTypeVersion string, p strea
 			case <-ctx.Done():
 				return
 			case e := <-eventChan:
+				if e.Shredded {
+					e.Acc()
+					continue
+				}
 				if e.Type == event.Deleted {
 					err := db.Update(func(txn *badger.Txn) error {
 						err = txn.Delete([]byte(getKey(e.Data)))
@@ -251,30 +258,32 @@ func (m *mapData[DT]) createEvent(data DT) (e event.Event[DT], err error) {
 		Metadata: event.Metadata{
 			Version:  m.dataTypeVersion,
 			DataType: m.dataTypeName,
-			Key:      crypto.SimpleHash(key),
+			Key:      crypto.KeyHash(key),
 		},
 	}
 	return
 }
 
-func (m *mapData[DT]) Delete(data DT) (err error) {
+// Delete writes a delete of data and returns its position once the map has applied it.
+func (m *mapData[DT]) Delete(data DT) (position uint64, err error) {
 	e := event.Event[DT]{
 		Type: event.Deleted,
 		Data: data,
 		Metadata: event.Metadata{
 			Version:  m.dataTypeVersion,
 			DataType: m.dataTypeName,
-			Key:      crypto.SimpleHash(m.getKey(data)),
+			Key:      crypto.KeyHash(m.getKey(data)),
 		},
 	}
 
 	we := event.NewWriteEvent(e)
 	m.es.Write() <- we
-	<-we.Done() //Missing error
-	return
+	status := <-we.Done()
+	return status.Position, status.Error
 }
 
-func (m *mapData[DT]) Set(data DT) (err error) {
+// Set writes data and returns its position once the map has applied it.
+func (m *mapData[DT]) Set(data DT) (position uint64, err error) {
 	log.Trace("Set and wait start")
 	e, err := m.createEvent(data)
 	if err != nil {
@@ -282,7 +291,18 @@ func (m *mapData[DT]) Set(data DT) (err error) {
 	}
 	we := event.NewWriteEvent(e)
 	m.es.Write() <- we
-	<-we.Done() //Missing error
-	log.Trace("Set and wait end")
-	return
+	status := <-we.Done()
+	log.Trace("Set and wait end", "position", status.Position)
+	return status.Position, status.Error
+}
+
+// WaitForPosition waits until the map has applied the events up to pos, or ctx is done. Positions up to the one the
+// map continued from at start are applied already. The map only reads events of its data type, so pos has to be the
+// position of one of them, like the positions Set and Delete return, or the wait lasts until the map applies a later
+// event.
+func (m *mapData[DT]) WaitForPosition(ctx context.Context, pos uint64) (err error) {
+	if pos <= m.from {
+		return
+	}
+	return m.es.WaitForPosition(ctx, pos)
 }
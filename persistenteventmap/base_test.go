@@ -5,9 +5,13 @@ import (
 	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	log "github.com/cantara/bragi/sbragi"
+	"github.com/cantara/gober/stream/checkpoint"
+	"github.com/cantara/gober/stream/event/store/inmemory"
 	"github.com/cantara/gober/stream/event/store/ondisk"
+	"github.com/dgraph-io/badger"
 	"github.com/gofrs/uuid"
 )
 
@@ -52,7 +56,12 @@ func TestStore(t *testing.T) {
 		Id:   1,
 		Name: "test",
 	}
-	err := ed.Set(data)
+	pos, err := ed.Set(data)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = ed.WaitForPosition(ctxGlobal, pos)
 	if err != nil {
 		t.Error(err)
 		return
@@ -77,6 +86,41 @@ func TestGet(t *testing.T) {
 	}
 }
 
+func TestWaitForPositionAfterRestart(t *testing.T) {
+	ctx, cancel := context.WithCancel(ctxGlobal)
+	defer cancel()
+	path := "./eventmap/restart"
+	os.RemoveAll(path)
+	defer os.RemoveAll(path)
+	pers, err := inmemory.Init(STREAM_NAME+"_restart", ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	db, err := badger.Open(badger.DefaultOptions(path))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = checkpoint.NewBadger(db).Save(fmt.Sprintf("%s_%s", pers.Name(), "restart"), 3)
+	db.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	m, err := Init[dd](pers, "restart", "1.0.0", cryptKeyProvider, func(d dd) string { return fmt.Sprint(d.Id) }, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	wctx, wcancel := context.WithTimeout(ctx, time.Second)
+	defer wcancel()
+	err = m.WaitForPosition(wctx, 3)
+	if err != nil {
+		t.Errorf("missmatch waiting for a position applied before the restart, %v", err)
+	}
+}
+
 func TestTairdown(t *testing.T) {
 	ctxGlobalCancel()
 }
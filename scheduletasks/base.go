@@ -8,6 +8,7 @@ import (
 
 	log "github.com/cantara/bragi/sbragi"
 	"github.com/cantara/gober/consensus"
+	"github.com/cantara/gober/stream/consumer"
 	"github.com/cantara/gober/stream/consumer/competing"
 
 	"github.com/cantara/gober/crypto"
@@ -185,6 +186,13 @@ func (s *scheduledtasks[DT]) handler(timeout time.Duration, skipable bool, execC
 	}
 }
 
+// RehashKeys copies a task stream to dst with metadata keys hashed by crypto.KeyHash, see consumer.RehashKeys.
+func RehashKeys[DT any](src, dst stream.Stream, p stream.CryptoKeyProvider, ctx context.Context) (n int, err error) {
+	return consumer.RehashKeys[tm[DT]](src, dst, p, func(data tm[DT]) string {
+		return data.Metadata.Id
+	}, ctx)
+}
+
 func (t *scheduledtasks[DT]) event(eventType event.Type, data tm[DT]) (e event.Event[tm[DT]]) {
 	e = event.Event[tm[DT]]{
 		Type: eventType,
@@ -192,7 +200,7 @@ func (t *scheduledtasks[DT]) event(eventType event.Type, data tm[DT]) (e event.E
 		Metadata: event.Metadata{
 			Version:  t.version,
 			DataType: t.dataType,
-			Key:      crypto.SimpleHash(data.Metadata.Id),
+			Key:      crypto.KeyHash(data.Metadata.Id),
 		},
 	}
 	return
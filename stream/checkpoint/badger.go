@@ -0,0 +1,49 @@
+package checkpoint
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/dgraph-io/badger"
+)
+
+// Badger is a Store keeping the positions in a Badger database, under the key name + "_position" as a little endian
+// uint64, the same layout persistenteventmap keeps its positions in.
+type Badger struct {
+	db *badger.DB
+}
+
+func NewBadger(db *badger.DB) *Badger {
+	return &Badger{
+		db: db,
+	}
+}
+
+func (b *Badger) Load(name string) (pos uint64, err error) {
+	err = b.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(positionKey(name))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			pos = binary.LittleEndian.Uint64(val)
+			return nil
+		})
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		err = nil
+	}
+	return
+}
+
+func (b *Badger) Save(name string, pos uint64) (err error) {
+	return b.db.Update(func(txn *badger.Txn) error {
+		val := make([]byte, 8)
+		binary.LittleEndian.PutUint64(val, pos)
+		return txn.Set(positionKey(name), val)
+	})
+}
+
+func positionKey(name string) []byte {
+	return []byte(name + "_position")
+}
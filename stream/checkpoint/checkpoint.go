@@ -0,0 +1,103 @@
+package checkpoint
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Store keeps the last completed stream position of named readers, so that they can continue from it after a restart.
+type Store interface {
+	// Load returns the saved position of name, or 0 if nothing is saved.
+	Load(name string) (pos uint64, err error)
+	Save(name string, pos uint64) (err error)
+}
+
+var InvalidNameError = errors.New("checkpoint name is not valid")
+
+var nameRegex = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,255}$`)
+
+// ValidName reports if name can be used as a checkpoint name. Names are used as file names, so they are limited to
+// letters, digits, dots, dashes and underscores.
+func ValidName(name string) bool {
+	return nameRegex.MatchString(name) && name != "." && name != ".."
+}
+
+// Memory is a Store that is lost on restart, for tests and for readers that only need to track their position.
+type Memory struct {
+	positions map[string]uint64
+	lock      sync.RWMutex
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		positions: make(map[string]uint64),
+	}
+}
+
+func (m *Memory) Load(name string) (pos uint64, err error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.positions[name], nil
+}
+
+func (m *Memory) Save(name string, pos uint64) (err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.positions[name] = pos
+	return
+}
+
+// File is a Store with one file per name in a directory.
+type File struct {
+	dir  string
+	lock sync.Mutex
+}
+
+func NewFile(dir string) (f *File, err error) {
+	err = os.MkdirAll(dir, 0750)
+	if err != nil {
+		return
+	}
+	f = &File{
+		dir: dir,
+	}
+	return
+}
+
+func (f *File) Load(name string) (pos uint64, err error) {
+	if !ValidName(name) {
+		err = fmt.Errorf("checkpoint %q, error:%w", name, InvalidNameError)
+		return
+	}
+	b, err := os.ReadFile(filepath.Join(f.dir, name))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		return
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+}
+
+// Save writes the position to a temporary file that is renamed over the checkpoint, so a crash never leaves a partly
+// written checkpoint.
+func (f *File) Save(name string, pos uint64) (err error) {
+	if !ValidName(name) {
+		err = fmt.Errorf("checkpoint %q, error:%w", name, InvalidNameError)
+		return
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	path := filepath.Join(f.dir, name)
+	err = os.WriteFile(path+".tmp", []byte(strconv.FormatUint(pos, 10)), 0640)
+	if err != nil {
+		return
+	}
+	return os.Rename(path+".tmp", path)
+}
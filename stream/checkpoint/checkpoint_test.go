@@ -0,0 +1,81 @@
+package checkpoint
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+
+	"github.com/cantara/gober/stream/event/store/inmemory"
+)
+
+func TestStores(t *testing.T) {
+	f, err := NewFile(t.TempDir())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	db, err := badger.Open(badger.DefaultOptions(t.TempDir()))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	es, err := inmemory.Init("checkpoints", ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	st, err := NewStream(es, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for name, s := range map[string]Store{"memory": NewMemory(), "file": f, "badger": NewBadger(db), "stream": st} {
+		pos, err := s.Load("reader")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if pos != 0 {
+			t.Errorf("missmatch %s initial position, %d != 0", name, pos)
+		}
+		for _, p := range []uint64{1, 42} {
+			err = s.Save("reader", p)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			pos, err = s.Load("reader")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if pos != p {
+				t.Errorf("missmatch %s position, %d != %d", name, pos, p)
+			}
+		}
+	}
+	st, err = NewStream(es, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	pos, err := st.Load("reader")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if pos != 42 {
+		t.Errorf("missmatch stream position after reopening, %d != 42", pos)
+	}
+	for _, name := range []string{"", "..", "a/b", "a b"} {
+		err = f.Save(name, 1)
+		if !errors.Is(err, InvalidNameError) {
+			t.Errorf("missmatch error saving %q, %v", name, err)
+		}
+	}
+}
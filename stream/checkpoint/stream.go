@@ -0,0 +1,127 @@
+package checkpoint
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/cantara/gober/stream"
+	"github.com/cantara/gober/stream/event"
+	"github.com/cantara/gober/stream/event/store"
+)
+
+var json = jsoniter.ConfigDefault
+
+// DataType is the data type of the events the Stream store writes.
+const DataType = "checkpoint"
+
+type position struct {
+	Name     string `json:"name"`
+	Position uint64 `json:"position"`
+}
+
+// Stream is a Store writing every saved position as an event to a stream, for services where the event store is the
+// only durable storage. The stream grows with every save, so give the checkpoints a stream of their own.
+type Stream struct {
+	stream    stream.Stream
+	positions map[string]uint64
+	lock      sync.Mutex
+	ctx       context.Context
+}
+
+// NewStream reads the positions saved in s up to its end.
+func NewStream(s stream.Stream, ctx context.Context) (cs *Stream, err error) {
+	cs = &Stream{
+		stream:    s,
+		positions: make(map[string]uint64),
+		ctx:       ctx,
+	}
+	end, err := s.End()
+	if err != nil || end == 0 {
+		return
+	}
+	rctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	events, err := s.Stream(store.STREAM_START, rctx)
+	if err != nil {
+		return
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			var p position
+			if json.Unmarshal(e.Data, &p) == nil && p.Name != "" {
+				cs.positions[p.Name] = p.Position
+			}
+			if e.Position >= end {
+				return
+			}
+		}
+	}
+}
+
+func (s *Stream) Load(name string) (pos uint64, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.positions[name], nil
+}
+
+// Save writes the position and waits for it to be written.
+func (s *Stream) Save(name string, pos uint64) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	id, err := uuid.NewV7()
+	if err != nil {
+		return
+	}
+	data, err := json.Marshal(position{
+		Name:     name,
+		Position: pos,
+	})
+	if err != nil {
+		return
+	}
+	metadata, err := json.Marshal(event.Metadata{
+		Stream:    s.stream.Name(),
+		EventType: event.Updated,
+		DataType:  DataType,
+		Key:       name,
+		Created:   time.Now(),
+	})
+	if err != nil {
+		return
+	}
+	status := make(chan store.WriteStatus, 1)
+	select {
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	case s.stream.Write() <- store.WriteEvent{
+		Event: store.Event{
+			Id:       id,
+			Type:     string(event.Updated),
+			Data:     data,
+			Metadata: metadata,
+		},
+		Status: status,
+	}:
+	}
+	select {
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	case ws := <-status:
+		if ws.Error != nil {
+			return ws.Error
+		}
+	}
+	s.positions[name] = pos
+	return
+}
@@ -0,0 +1,126 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cantara/gober/mergedcontext"
+	"github.com/cantara/gober/stream"
+	"github.com/cantara/gober/stream/checkpoint"
+	"github.com/cantara/gober/stream/event"
+	"github.com/cantara/gober/stream/event/store"
+)
+
+var InvalidBatchSizeError = errors.New("batch size has to be at least 1")
+
+// StreamBatches streams the selected events in batches of up to size events, a batch is delivered when it is full or
+// linger after its first event, whichever comes first. Acknowledging a batch acknowledges all its events at once.
+// Nacked batches are redelivered whole by the retry policy of the stream, and have all their events parked when they
+// are nacked on their last attempt.
+func (c *consumer[T]) StreamBatches(sel store.Selector, from store.StreamPosition, filter stream.Filter, size int, linger time.Duration, ctx context.Context) (out <-chan event.ReadBatchWAcc[T], err error) {
+	s, err := c.streamBatches(sel, from, filter, size, linger, ctx)
+	if err != nil {
+		return
+	}
+	out = c.retryingBatches(s, ctx)
+	return
+}
+
+// SubscribeBatches streams the selected events in batches like StreamBatches, from the position saved for name in
+// checkpoints like Subscribe. The saved position advances over whole batches. Nacked batches are retried like with
+// StreamBatches.
+func (c *consumer[T]) SubscribeBatches(name string, checkpoints checkpoint.Store, sel store.Selector, filter stream.Filter, size int, linger time.Duration, ctx context.Context) (out <-chan event.ReadBatchWAcc[T], err error) {
+	from, err := checkpoints.Load(name)
+	if err != nil {
+		return
+	}
+	mctx, cancel := mergedcontext.MergeContexts(c.ctx, ctx)
+	s, err := c.streamBatches(sel, store.StreamPosition(from), filter, size, linger, mctx)
+	if err != nil {
+		cancel()
+		return
+	}
+	out = c.retryingBatches(relay(newSubscription(name, checkpoints, from), s, func(b event.ReadBatchWAcc[T], ack func()) (event.ReadBatchWAcc[T], uint64) {
+		acc := b.Acc
+		b.Acc = func() {
+			acc()
+			ack()
+		}
+		return b, b.Events[len(b.Events)-1].Position
+	}, mctx, cancel), mctx)
+	return
+}
+
+func (c *consumer[T]) streamBatches(sel store.Selector, from store.StreamPosition, filter stream.Filter, size int, linger time.Duration, ctx context.Context) (out <-chan event.ReadBatchWAcc[T], err error) {
+	if size < 1 {
+		err = fmt.Errorf("batch size %d, error:%w", size, InvalidBatchSizeError)
+		return
+	}
+	mctx, cancel := mergedcontext.MergeContexts(c.ctx, ctx)
+	s, err := c.stream.StreamSelected(sel, from, filter, mctx)
+	if err != nil {
+		cancel()
+		return
+	}
+	batchChan := make(chan event.ReadBatchWAcc[T], 0)
+	out = batchChan
+	go func() {
+		defer cancel()
+		var batch []event.ReadEvent[T]
+		var raw []event.ReadEvent[[]byte]
+		var lingering <-chan time.Time
+		var timer *time.Timer
+		for {
+			select {
+			case <-mctx.Done():
+				return
+			case e, ok := <-s:
+				if !ok {
+					return
+				}
+				o, err := decryptEvent[T](e, c.cryptoKey, c.opts)
+				if err != nil {
+					c.opts.ReportReadError(stream.ReadError{Stream: c.stream.Name(), Event: StoredEvent(e), Stage: stream.StageData, Err: err}, mctx)
+					continue
+				}
+				batch = append(batch, o)
+				raw = append(raw, e)
+				if len(batch) == 1 && size > 1 {
+					timer = time.NewTimer(linger)
+					lingering = timer.C
+				}
+				if len(batch) < size {
+					continue
+				}
+			case <-lingering:
+			}
+			if timer != nil {
+				timer.Stop()
+				timer = nil
+				lingering = nil
+			}
+			position := batch[len(batch)-1].Position
+			read := raw
+			select {
+			case <-mctx.Done():
+				return
+			case batchChan <- event.ReadBatchWAcc[T]{
+				Events: batch,
+				Acc: func() {
+					c.accChan <- position
+				},
+				Nack: func(err error) {
+					for _, e := range read {
+						c.opts.ReportReadError(stream.ReadError{Stream: c.stream.Name(), Event: StoredEvent(e), Stage: stream.StageHandler, Err: err}, c.ctx)
+					}
+				},
+			}:
+			}
+			batch = nil
+			raw = nil
+		}
+	}()
+	return
+}
@@ -19,7 +19,7 @@ import (
 type timeoutFunk[T any] func(v T) time.Duration
 
 type service[T any] struct {
-	stream    consumer.Consumer[tm[T]]
+	stream    consumer.SelectedConsumer[tm[T]]
 	cryptoKey stream.CryptoKeyProvider
 	dataType  string
 	//timeout        time.Duration
@@ -69,7 +69,7 @@ func New[T any](s stream.Stream, consBuilder consensus.ConsBuilderFunc, cryptoKe
 		timeout:         timeout,
 		ctx:             ctx,
 	}
-	eventStream, err := c.stream.Stream(event.AllTypes(), from, stream.ReadDataType(datatype), c.ctx)
+	eventStream, err := c.stream.StreamSelected(stream.SelectDataTypes(datatype), from, nil, c.ctx)
 	if err != nil {
 		return
 	}
@@ -177,7 +177,7 @@ func (c *service[T]) selectableHandler(timeout chan<- event.ReadEvent[tm[T]]) {
 					continue
 				}
 				log.Trace("won competition")
-				ctx, cancel := context.WithTimeout(c.ctx, c.timeout(e.Data.Data)) //c.timeout)
+				ctx, cancel := context.WithTimeout(event.ContextWithEvent(c.ctx, e.Id, e.Metadata), c.timeout(e.Data.Data)) //c.timeout)
 				selected = &seldat[tm[T]]{
 					e:      e,
 					ctx:    ctx,
@@ -205,6 +205,7 @@ func (c *service[T]) selectableHandler(timeout chan<- event.ReadEvent[tm[T]]) {
 			case c.selectedOutput <- ReadEventWAcc[T]{
 				ReadEvent: event.ReadEvent[T]{
 					Event: event.Event[T]{
+						Id:       selected.e.Id,
 						Type:     selected.e.Type,
 						Data:     selected.e.Data.Data,
 						Metadata: selected.e.Metadata,
@@ -216,13 +217,15 @@ func (c *service[T]) selectableHandler(timeout chan<- event.ReadEvent[tm[T]]) {
 				Acc: func(cancel context.CancelFunc, e event.ReadEvent[tm[T]]) func(T) {
 					return func(data T) {
 						cancel()
-						we := event.NewWriteEvent[tm[T]](event.Event[tm[T]]{
+						md := e.Metadata
+						md.CausationId = "" //The completion is caused by the selected event, set from the context
+						we := event.NewWriteEventWithContext[tm[T]](event.ContextWithEvent(c.ctx, e.Id, e.Metadata), event.Event[tm[T]]{
 							Type: event.Deleted,
 							Data: tm[T]{
 								Id:   e.Data.Id,
 								Data: data,
 							}, //e.Data,
-							Metadata: e.Metadata,
+							Metadata: md,
 						})
 						c.stream.Write() <- we
 						status := <-we.Done()
@@ -260,6 +263,9 @@ func (c *service[T]) readStream(events <-chan event.ReadEventWAcc[tm[T]], timeou
 		e := <-events
 		e.Acc()
 		p = e.Position
+		if e.Shredded {
+			continue
+		}
 		switch e.Type {
 		case event.Created:
 			fallthrough
@@ -307,6 +313,9 @@ func (c *service[T]) readStream(events <-chan event.ReadEventWAcc[tm[T]], timeou
 	for e := range events { //Since this stream is controlled by us, we range over it until it closes
 		log.Trace("range read", "event", e.Data.Id.String())
 		e.Acc()
+		if e.Shredded {
+			continue
+		}
 		switch e.Type {
 		case event.Created:
 			fallthrough
@@ -389,7 +398,7 @@ func (c *service[T]) Completed() <-chan event.ReadEvent[T] {
 }
 
 func (c *service[T]) End() (pos uint64, err error) {
-	return c.stream.FilteredEnd(event.AllTypes(), stream.ReadDataType(c.dataType))
+	return c.stream.SelectedEnd(stream.SelectDataTypes(c.dataType), nil)
 }
 
 func (c *service[T]) Name() string {
@@ -2,6 +2,8 @@ package consumer
 
 import (
 	"context"
+	"encoding/binary"
+	"errors"
 	"time"
 
 	log "github.com/cantara/bragi/sbragi"
@@ -9,6 +11,8 @@ import (
 	"github.com/cantara/gober/mergedcontext"
 	"github.com/cantara/gober/stream"
 	"github.com/cantara/gober/stream/event"
+	"github.com/cantara/gober/stream/event/codec"
+	"github.com/cantara/gober/stream/event/compression"
 	"github.com/cantara/gober/stream/event/store"
 	"github.com/gofrs/uuid"
 	jsoniter "github.com/json-iterator/go"
@@ -16,14 +20,20 @@ import (
 
 var json = jsoniter.ConfigDefault
 
+var MissingClaimCheckStoreError = errors.New("event data is claim checked but no blob store is configured")
+var MissingKeyRingError = errors.New("event data is encrypted with a key id but no key ring is configured")
+var MissingCryptoKeyProviderError = errors.New("event data is encrypted without a key id but no crypto key provider is configured")
+var MissingAADError = errors.New("event data is encrypted without associated data but associated data is required")
+
 type consumer[T any] struct {
-	stream             stream.FilteredStream[[]byte]
+	stream             stream.SelectedStream[[]byte]
 	cryptoKey          stream.CryptoKeyProvider
 	newTransactionChan chan transactionCheck
 	currentPosition    uint64
 	completables       map[string]transactionCheck
 	accChan            chan uint64
 	writeStream        chan event.WriteEventReadStatus[T]
+	opts               stream.Options
 	ctx                context.Context
 }
 
@@ -32,8 +42,8 @@ type transactionCheck struct {
 	complete func()
 }
 
-func New[T any](s stream.Stream, cryptoKey stream.CryptoKeyProvider, ctx context.Context) (out Consumer[T], err error) {
-	fs, err := stream.Init[[]byte](s, ctx)
+func New[T any](s stream.Stream, cryptoKey stream.CryptoKeyProvider, ctx context.Context, opts ...stream.Option) (out SelectedConsumer[T], err error) {
+	fs, err := stream.Init[[]byte](s, ctx, opts...)
 	if err != nil {
 		return
 	}
@@ -44,6 +54,7 @@ func New[T any](s stream.Stream, cryptoKey stream.CryptoKeyProvider, ctx context
 		completables:       make(map[string]transactionCheck), //NewMap[transactionCheck](),
 		accChan:            make(chan uint64, 0),              //1000),
 		writeStream:        make(chan event.WriteEventReadStatus[T], 0),
+		opts:               stream.NewOptions(opts...),
 		ctx:                ctx,
 	}
 
@@ -90,17 +101,69 @@ func (c *consumer[T]) Write() chan<- event.WriteEventReadStatus[T] {
 }
 
 func (c *consumer[T]) Stream(eventTypes []event.Type, from store.StreamPosition, filter stream.Filter, ctx context.Context) (out <-chan event.ReadEventWAcc[T], err error) {
-	return c.streamReadEvents(eventTypes, from, filter, ctx)
+	return c.StreamSelected(stream.SelectEventTypes(eventTypes...), from, filter, ctx)
+}
+
+func (c *consumer[T]) StreamSelected(sel store.Selector, from store.StreamPosition, filter stream.Filter, ctx context.Context) (out <-chan event.ReadEventWAcc[T], err error) {
+	s, err := c.streamReadEvents(sel, from, filter, ctx)
+	if err != nil {
+		return
+	}
+	out = c.retrying(s, ctx)
+	return
+}
+
+// WaitForPosition waits until the reader side of the consumer has acknowledged pos, so a read after it sees the
+// events up to pos, like writes only complete once their position is acknowledged.
+func (c *consumer[T]) WaitForPosition(ctx context.Context, pos uint64) (err error) {
+	done := make(chan struct{})
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.ctx.Done():
+		return c.ctx.Err()
+	case c.newTransactionChan <- transactionCheck{
+		position: pos,
+		complete: func() {
+			close(done)
+		},
+	}:
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.ctx.Done():
+		return c.ctx.Err()
+	case <-done:
+	}
+	return
 }
 
 func (c *consumer[T]) store(e event.WriteEventReadStatus[T]) (position uint64, err error) {
-	es, err := EncryptEvent[T](e.Event(), c.cryptoKey)
+	if e.Event().Id.IsNil() {
+		e.Event().Id, err = uuid.NewV7()
+		if err != nil {
+			e.Close(store.WriteStatus{
+				Error: err,
+			})
+			return
+		}
+	}
+	e.Event().Metadata.ApplyTrace(e.Context(), e.Event().Id)
+	e.Event().Metadata.Stream = c.stream.Name()
+	es, err := encryptEvent[T](e.Event(), c.cryptoKey, c.opts)
 	if err != nil {
+		e.Close(store.WriteStatus{
+			Error: err,
+		})
 		return
 	}
 
 	position, err = c.stream.Store(es)
 	if err != nil {
+		e.Close(store.WriteStatus{
+			Error: err,
+		})
 		return
 	}
 	c.newTransactionChan <- transactionCheck{
@@ -131,9 +194,9 @@ func (c *consumer[T]) streamWriteEvents(eventStream <-chan event.WriteEventReadS
 	return
 }
 
-func (c *consumer[T]) streamReadEvents(eventTypes []event.Type, from store.StreamPosition, filter stream.Filter, ctx context.Context) (out <-chan event.ReadEventWAcc[T], err error) {
+func (c *consumer[T]) streamReadEvents(sel store.Selector, from store.StreamPosition, filter stream.Filter, ctx context.Context) (out <-chan event.ReadEventWAcc[T], err error) {
 	mctx, cancel := mergedcontext.MergeContexts(c.ctx, ctx)
-	s, err := c.stream.Stream(eventTypes, from, filter, mctx)
+	s, err := c.stream.StreamSelected(sel, from, filter, mctx)
 	if err != nil {
 		cancel()
 		return
@@ -146,18 +209,28 @@ func (c *consumer[T]) streamReadEvents(eventTypes []event.Type, from store.Strea
 			select {
 			case <-mctx.Done():
 				return
-			case e := <-s:
-				o, err := DecryptEvent[T](e, c.cryptoKey)
+			case e, ok := <-s:
+				if !ok {
+					return
+				}
+				o, err := decryptEvent[T](e, c.cryptoKey, c.opts)
 				if err != nil {
-					log.WithError(err).Error("while reading event")
+					c.opts.ReportReadError(stream.ReadError{Stream: c.stream.Name(), Event: StoredEvent(e), Stage: stream.StageData, Err: err}, mctx)
 					continue
 				}
-				eventChan <- event.ReadEventWAcc[T]{
+				select {
+				case <-mctx.Done():
+					return
+				case eventChan <- event.ReadEventWAcc[T]{
 					ReadEvent: o,
 					Acc: func() {
 						c.accChan <- o.Position
 					},
-					CTX: c.ctx,
+					Nack: func(err error) {
+						c.opts.ReportReadError(stream.ReadError{Stream: c.stream.Name(), Event: StoredEvent(e), Stage: stream.StageHandler, Err: err}, c.ctx)
+					},
+					CTX: event.ContextWithEvent(c.ctx, o.Id, o.Metadata),
+				}:
 				}
 			}
 		}
@@ -165,6 +238,24 @@ func (c *consumer[T]) streamReadEvents(eventTypes []event.Type, from store.Strea
 	return
 }
 
+// StoredEvent returns the event as it is stored, the raw stream only decodes the data envelope.
+func StoredEvent(e event.ReadEvent[[]byte]) store.ReadEvent {
+	metadata, err := json.Marshal(e.Metadata)
+	log.WithError(err).Debug("Marshalling read event metadata", "position", e.Position)
+	data, err := json.Marshal(e.Data)
+	log.WithError(err).Debug("Marshalling read event data", "position", e.Position)
+	return store.ReadEvent{
+		Event: store.Event{
+			Id:       e.Id,
+			Type:     string(e.Type),
+			Data:     data,
+			Metadata: metadata,
+		},
+		Position: e.Position,
+		Created:  e.Created,
+	}
+}
+
 func (c *consumer[T]) End() (pos uint64, err error) {
 	return c.stream.End()
 }
@@ -177,16 +268,57 @@ func (c *consumer[T]) FilteredEnd(eventTypes []event.Type, filter stream.Filter)
 	return c.stream.FilteredEnd(eventTypes, filter)
 }
 
-func EncryptEvent[T any](e *event.Event[T], cryptoKey stream.CryptoKeyProvider) (es event.Event[[]byte], err error) {
-	data, err := json.Marshal(e.Data)
+func (c *consumer[T]) CachedFilteredEnd(eventTypes []event.Type, filterKey string, filter stream.Filter) (pos uint64, err error) {
+	return c.stream.CachedFilteredEnd(eventTypes, filterKey, filter)
+}
+
+func (c *consumer[T]) SelectedEnd(sel store.Selector, filter stream.Filter) (pos uint64, err error) {
+	return c.stream.SelectedEnd(sel, filter)
+}
+
+func EncryptEvent[T any](e *event.Event[T], cryptoKey stream.CryptoKeyProvider, opts ...stream.Option) (es event.Event[[]byte], err error) {
+	return encryptEvent(e, cryptoKey, stream.NewOptions(opts...))
+}
+
+func encryptEvent[T any](e *event.Event[T], cryptoKey stream.CryptoKeyProvider, opts stream.Options) (es event.Event[[]byte], err error) {
+	if e.Id.IsNil() {
+		e.Id, err = uuid.NewV7()
+		if err != nil {
+			return
+		}
+	}
+	dataCodec, err := codec.Resolve(e.Metadata.Codec, e.Metadata.DataType, opts.Codec)
+	if err != nil {
+		return
+	}
+	e.Metadata.Codec = dataCodec.Name()
+	data, err := dataCodec.Marshal(e.Data)
+	if err != nil {
+		return
+	}
+	size := len(data)
+	data, e.Metadata.Compression, err = compression.Compress(opts.Compressor, opts.CompressionThreshold, data)
 	if err != nil {
 		return
 	}
-	edata, err := crypto.Encrypt(data, cryptoKey(e.Metadata.Key))
+	err = opts.CheckDecompressedSize(e.Metadata.Compression, size)
 	if err != nil {
 		return
 	}
+	edata, err := encryptData(data, e.Id, e.Metadata, cryptoKey, opts)
+	if err != nil {
+		return
+	}
+	e.Metadata.ClaimCheck = ""
+	if opts.ClaimCheck != nil && len(edata) > opts.ClaimCheckThreshold {
+		e.Metadata.ClaimCheck, err = opts.ClaimCheck.Put(edata)
+		if err != nil {
+			return
+		}
+		edata = nil
+	}
 	ev, err := event.NewBuilder().
+		WithId(e.Id).
 		WithType(e.Type).
 		WithMetadata(e.Metadata).
 		WithData(edata).
@@ -198,26 +330,136 @@ func EncryptEvent[T any](e *event.Event[T], cryptoKey stream.CryptoKeyProvider)
 	return
 }
 
-func DecryptEvent[T any](e event.ReadEvent[[]byte], cryptoKey stream.CryptoKeyProvider) (out event.ReadEvent[T], err error) {
-	dataJson, err := crypto.Decrypt(e.Data, cryptoKey(e.Metadata.Key))
+// DecryptEvent decrypts and decodes the event data. Events whose key is shredded are returned as tombstones,
+// with Shredded set and only the metadata available, rather than as errors.
+func DecryptEvent[T any](e event.ReadEvent[[]byte], cryptoKey stream.CryptoKeyProvider, opts ...stream.Option) (out event.ReadEvent[T], err error) {
+	return decryptEvent[T](e, cryptoKey, stream.NewOptions(opts...))
+}
+
+func decryptEvent[T any](e event.ReadEvent[[]byte], cryptoKey stream.CryptoKeyProvider, opts stream.Options) (out event.ReadEvent[T], err error) {
+	dataCodec, err := codec.ForName(e.Metadata.Codec)
 	if err != nil {
+		log.WithError(err).Error("Selecting event data codec error")
+		return
+	}
+	e.Data, err = resolveClaimCheck(e.Data, e.Metadata, opts)
+	if err != nil {
+		log.WithError(err).Error("Resolving event data claim check error", "ref", e.Metadata.ClaimCheck)
+		return
+	}
+	dataEncoded, err := decryptData(e.Data, e.Id, e.Metadata, cryptoKey, opts)
+	if err != nil {
+		if errors.Is(err, crypto.KeyShreddedError) {
+			log.Debug("Decrypting event with shredded key", "position", e.Position)
+			out = event.ReadEvent[T]{
+				Event: event.Event[T]{
+					Id:       e.Id,
+					Type:     e.Type,
+					Metadata: e.Metadata,
+				},
+				Position: e.Position,
+				Created:  e.Created,
+				Shredded: true,
+				Verified: e.Verified,
+			}
+			err = nil
+			return
+		}
 		log.WithError(err).Warning("Decrypting event data error")
 		return
 	}
+	dataEncoded, err = compression.DecompressLimit(e.Metadata.Compression, dataEncoded, opts.DecompressLimit())
+	if err != nil {
+		log.WithError(err).Warning("Decompressing event data error")
+		return
+	}
+	dataEncoded, err = event.Upcast(&e.Metadata, dataEncoded)
+	if err != nil {
+		log.WithError(err).Error("Upcasting event data error")
+		return
+	}
 	var data T
-	err = json.Unmarshal(dataJson, &data)
+	err = dataCodec.Unmarshal(dataEncoded, &data)
 	if err != nil {
 		log.WithError(err).Warning("Unmarshalling event data error")
 		return
 	}
 	out = event.ReadEvent[T]{
 		Event: event.Event[T]{
+			Id:       e.Id,
 			Type:     e.Type,
 			Data:     data,
 			Metadata: e.Metadata,
 		},
 		Position: e.Position,
 		Created:  e.Created,
+		Verified: e.Verified,
 	}
 	return
 }
+
+func resolveClaimCheck(data []byte, md event.Metadata, opts stream.Options) ([]byte, error) {
+	if md.ClaimCheck == "" {
+		return data, nil
+	}
+	if opts.ClaimCheck == nil {
+		return nil, MissingClaimCheckStoreError
+	}
+	return opts.ClaimCheck.Get(md.ClaimCheck)
+}
+
+// eventAAD binds a ciphertext to the event it belongs to, so that it can not be moved to another event, stream,
+// data type or key. Fields are length prefixed to keep the encoding unambiguous.
+func eventAAD(id uuid.UUID, md event.Metadata) []byte {
+	aad := make([]byte, 0, len(id)+len(md.Stream)+len(md.DataType)+len(md.Key)+3*binary.MaxVarintLen64)
+	aad = append(aad, id.Bytes()...)
+	for _, field := range []string{md.Stream, md.DataType, md.Key} {
+		aad = binary.AppendUvarint(aad, uint64(len(field)))
+		aad = append(aad, field...)
+	}
+	return aad
+}
+
+func encryptData(data []byte, id uuid.UUID, md event.Metadata, cryptoKey stream.CryptoKeyProvider, opts stream.Options) ([]byte, error) {
+	if opts.KeyRing != nil {
+		keyId, key, err := opts.KeyRing.Current(md.Key)
+		if err != nil {
+			return nil, err
+		}
+		return crypto.EncryptEnvelopeAAD(opts.Cipher, data, eventAAD(id, md), keyId, key)
+	}
+	if cryptoKey == nil {
+		return nil, MissingCryptoKeyProviderError
+	}
+	return crypto.EncryptEnvelopeAAD(opts.Cipher, data, eventAAD(id, md), "", cryptoKey(md.Key))
+}
+
+// decryptData decrypts enveloped data with the key ring, or the crypto key provider for envelopes without key id,
+// and legacy data, which has no associated data, with the crypto key provider. Data without associated data is rejected
+// when the options require it. The decrypt key in the options is used over cryptoKey.
+func decryptData(ciphertext []byte, id uuid.UUID, md event.Metadata, cryptoKey stream.CryptoKeyProvider, opts stream.Options) ([]byte, error) {
+	if opts.RequireAAD && !crypto.EnvelopeHasAAD(ciphertext) {
+		return nil, MissingAADError
+	}
+	if opts.DecryptKey != nil {
+		cryptoKey = opts.DecryptKey
+	}
+	if _, ok := crypto.EnvelopeKeyId(ciphertext); ok {
+		return crypto.DecryptEnvelopeAAD(ciphertext, eventAAD(id, md), func(keyId string) (log.RedactedString, error) {
+			if keyId == "" {
+				if cryptoKey == nil {
+					return "", MissingCryptoKeyProviderError
+				}
+				return cryptoKey(md.Key), nil
+			}
+			if opts.KeyRing == nil {
+				return "", MissingKeyRingError
+			}
+			return opts.KeyRing.Key(md.Key, keyId)
+		})
+	}
+	if cryptoKey == nil {
+		return nil, MissingCryptoKeyProviderError
+	}
+	return crypto.Decrypt(ciphertext, cryptoKey(md.Key))
+}
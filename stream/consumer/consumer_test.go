@@ -2,18 +2,26 @@ package consumer
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"testing"
+	"time"
 
 	log "github.com/cantara/bragi/sbragi"
+	"github.com/cantara/gober/crypto"
+	"github.com/cantara/gober/crypto/keystore"
 	"github.com/cantara/gober/stream"
+	"github.com/cantara/gober/stream/checkpoint"
 	"github.com/cantara/gober/stream/event/store/inmemory"
 	"github.com/cantara/gober/stream/event/store/ondisk"
 
 	"github.com/gofrs/uuid"
 
 	"github.com/cantara/gober/stream/event"
+	"github.com/cantara/gober/stream/event/claimcheck"
+	"github.com/cantara/gober/stream/event/codec"
+	"github.com/cantara/gober/stream/event/compression"
 	"github.com/cantara/gober/stream/event/store"
 )
 
@@ -123,6 +131,1441 @@ func TestStreamOrder(t *testing.T) {
 	return
 }
 
+func TestStreamCodec(t *testing.T) {
+	ctx, cancel := context.WithCancel(ctxGlobal)
+	defer cancel()
+	pers, err := inmemory.Init(STREAM_NAME+"_codec", ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	c, err := New[dd](pers, cryptKeyProvider, ctx, stream.WithCodec(codec.MsgPack))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	readEventStream, err := c.Stream(event.AllTypes(), store.STREAM_START, stream.ReadAll(), ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	go func() {
+		for e := range readEventStream {
+			e.Acc()
+		}
+	}()
+	we := event.NewWriteEvent(event.Event[dd]{
+		Type: event.Created,
+		Data: dd{
+			Id:   1,
+			Name: "codec",
+		},
+	})
+	c.Write() <- we
+	status := <-we.Done()
+	if status.Error != nil {
+		t.Error(status.Error)
+		return
+	}
+	raw, err := stream.Init[[]byte](pers, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	rawStream, err := raw.Stream(event.AllTypes(), store.STREAM_START, stream.ReadAll(), ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	re := <-rawStream
+	if re.Metadata.Codec != codec.MsgPackName {
+		t.Error(fmt.Errorf("missmatch event metadata codec, %s != %s", re.Metadata.Codec, codec.MsgPackName))
+		return
+	}
+	e, err := DecryptEvent[dd](re, cryptKeyProvider)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if e.Data.Id != 1 || e.Data.Name != "codec" {
+		t.Error(fmt.Errorf("missmatch event data, %v", e.Data))
+	}
+}
+
+func TestStreamCompression(t *testing.T) {
+	ctx, cancel := context.WithCancel(ctxGlobal)
+	defer cancel()
+	pers, err := inmemory.Init(STREAM_NAME+"_compression", ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	c, err := New[dd](pers, cryptKeyProvider, ctx, stream.WithCompression(compression.Zstd, 64))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	readEventStream, err := c.Stream(event.AllTypes(), store.STREAM_START, stream.ReadAll(), ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	names := []string{"small", fmt.Sprintf("%0128d", 0)}
+	for i, name := range names {
+		we := event.NewWriteEvent(event.Event[dd]{
+			Type: event.Created,
+			Data: dd{
+				Id:   i,
+				Name: name,
+			},
+		})
+		go func() {
+			c.Write() <- we
+		}()
+		e := <-readEventStream
+		e.Acc()
+		status := <-we.Done()
+		if status.Error != nil {
+			t.Error(status.Error)
+			return
+		}
+		if e.Data.Name != name {
+			t.Error(fmt.Errorf("missmatch event data name, %s != %s", e.Data.Name, name))
+			return
+		}
+		expected := compression.ZstdName
+		if i == 0 {
+			expected = ""
+		}
+		if e.Metadata.Compression != expected {
+			t.Error(fmt.Errorf("missmatch event metadata compression, %q != %q", e.Metadata.Compression, expected))
+			return
+		}
+	}
+}
+
+func TestTraceContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(ctxGlobal)
+	defer cancel()
+	pers, err := inmemory.Init(STREAM_NAME+"_trace", ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	c, err := New[dd](pers, cryptKeyProvider, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	readEventStream, err := c.Stream(event.AllTypes(), store.STREAM_START, stream.ReadAll(), ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	traceParent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	we := event.NewWriteEventWithContext(event.ContextWithTraceParent(ctx, traceParent), event.Event[dd]{
+		Type: event.Created,
+		Data: dd{
+			Id:   1,
+			Name: "cause",
+		},
+	})
+	go func() {
+		c.Write() <- we
+	}()
+	cause := <-readEventStream
+	cause.Acc()
+	<-we.Done()
+	if cause.Id.IsNil() {
+		t.Error("event id was not set")
+		return
+	}
+	if cause.Metadata.CorrelationId != cause.Id.String() || cause.Metadata.CausationId != "" {
+		t.Error(fmt.Errorf("root event should be correlated with itself, %v", cause.Metadata))
+		return
+	}
+	if cause.Metadata.TraceParent != traceParent {
+		t.Error(fmt.Errorf("missmatch traceparent, %s != %s", cause.Metadata.TraceParent, traceParent))
+		return
+	}
+	we = event.NewWriteEventWithContext(cause.CTX, event.Event[dd]{
+		Type: event.Created,
+		Data: dd{
+			Id:   2,
+			Name: "effect",
+		},
+	})
+	go func() {
+		c.Write() <- we
+	}()
+	effect := <-readEventStream
+	effect.Acc()
+	<-we.Done()
+	if effect.Metadata.CorrelationId != cause.Id.String() || effect.Metadata.CausationId != cause.Id.String() {
+		t.Error(fmt.Errorf("follow-up event should be correlated with and caused by the first event, %v", effect.Metadata))
+		return
+	}
+	if effect.Metadata.TraceParent != traceParent {
+		t.Error(fmt.Errorf("missmatch traceparent, %s != %s", effect.Metadata.TraceParent, traceParent))
+	}
+}
+
+func TestClaimCheck(t *testing.T) {
+	ctx, cancel := context.WithCancel(ctxGlobal)
+	defer cancel()
+	pers, err := inmemory.Init(STREAM_NAME+"_claimcheck", ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	blobs, err := claimcheck.NewLocal(t.TempDir())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	c, err := New[dd](pers, cryptKeyProvider, ctx, stream.WithClaimCheck(blobs, 1024), stream.WithMaxEventSize(2048))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	readEventStream, err := c.Stream(event.AllTypes(), store.STREAM_START, stream.ReadAll(), ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	name := fmt.Sprintf("%04096d", 0)
+	we := event.NewWriteEvent(event.Event[dd]{
+		Type: event.Created,
+		Data: dd{
+			Id:   1,
+			Name: name,
+		},
+	})
+	go func() {
+		c.Write() <- we
+	}()
+	e := <-readEventStream
+	e.Acc()
+	status := <-we.Done()
+	if status.Error != nil {
+		t.Error(status.Error)
+		return
+	}
+	if e.Metadata.ClaimCheck == "" {
+		t.Error("expected large payload to be claim checked")
+		return
+	}
+	if e.Data.Name != name {
+		t.Error("missmatch claim checked event data")
+		return
+	}
+	_, err = blobs.Get(e.Metadata.ClaimCheck)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	md := e.Metadata
+	we = event.NewWriteEvent(event.Event[dd]{
+		Type: event.Updated,
+		Data: dd{
+			Id:   2,
+			Name: "small",
+		},
+		Metadata: md,
+	})
+	go func() {
+		c.Write() <- we
+	}()
+	e = <-readEventStream
+	e.Acc()
+	status = <-we.Done()
+	if status.Error != nil {
+		t.Error(status.Error)
+		return
+	}
+	if e.Metadata.ClaimCheck != "" || e.Data.Name != "small" {
+		t.Errorf("missmatch small event written with copied claim checked metadata, %v", e.ReadEvent)
+	}
+}
+
+func TestMaxEventSize(t *testing.T) {
+	ctx, cancel := context.WithCancel(ctxGlobal)
+	defer cancel()
+	pers, err := inmemory.Init(STREAM_NAME+"_max_event_size", ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	c, err := New[dd](pers, cryptKeyProvider, ctx, stream.WithMaxEventSize(512))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	we := event.NewWriteEvent(event.Event[dd]{
+		Type: event.Created,
+		Data: dd{
+			Id:   1,
+			Name: fmt.Sprintf("%01024d", 0),
+		},
+	})
+	c.Write() <- we
+	select {
+	case status := <-we.Done():
+		if !errors.Is(status.Error, stream.EventTooLargeError) {
+			t.Errorf("missmatch error writing oversized event, %v", status.Error)
+			return
+		}
+	case <-time.After(time.Second):
+		t.Error("timed out waiting for the oversized write to fail")
+		return
+	}
+
+	e := event.Event[dd]{
+		Type: event.Created,
+		Data: dd{
+			Id:   2,
+			Name: fmt.Sprintf("%01024d", 0),
+		},
+	}
+	_, err = EncryptEvent(&e, cryptKeyProvider, stream.WithCompression(compression.Zstd, 64), stream.WithMaxDecompressedSize(512))
+	if !errors.Is(err, stream.EventTooLargeError) {
+		t.Errorf("missmatch error compressing data over the max decompressed size, %v", err)
+		return
+	}
+	es, err := EncryptEvent(&e, cryptKeyProvider, stream.WithCompression(compression.Zstd, 64))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(es.Data) >= 512 {
+		t.Errorf("missmatch compressed size, %d >= 512", len(es.Data))
+		return
+	}
+	_, err = DecryptEvent[dd](event.ReadEvent[[]byte]{Event: es}, cryptKeyProvider, stream.WithMaxDecompressedSize(512))
+	if !errors.Is(err, compression.DataTooLargeError) {
+		t.Errorf("missmatch error decompressing data over the max decompressed size, %v", err)
+	}
+}
+
+func TestShreddedTombstone(t *testing.T) {
+	ctx, cancel := context.WithCancel(ctxGlobal)
+	defer cancel()
+	pers, err := inmemory.Init(STREAM_NAME+"_shredded", ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ks, err := keystore.NewLocal(t.TempDir(), testCryptKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	c, err := New[dd](pers, ks.Provider(), ctx, stream.WithDecryptKey(ks.LookupProvider()))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	readEventStream, err := c.Stream(event.AllTypes(), store.STREAM_START, stream.ReadAll(), ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	go func() {
+		for e := range readEventStream {
+			e.Acc()
+		}
+	}()
+	for i, subject := range []string{"forget_me", "keep_me"} {
+		we := event.NewWriteEvent(event.Event[dd]{
+			Type: event.Created,
+			Data: dd{
+				Id:   i,
+				Name: subject,
+			},
+			Metadata: event.Metadata{
+				Key: subject,
+			},
+		})
+		c.Write() <- we
+		status := <-we.Done()
+		if status.Error != nil {
+			t.Error(status.Error)
+			return
+		}
+	}
+	err = ks.Shred("forget_me")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s, err := c.Stream(event.AllTypes(), store.STREAM_START, stream.ReadAll(), ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	e := <-s
+	if !e.Shredded || e.Data.Name != "" || e.Metadata.Key != "forget_me" {
+		t.Error(fmt.Errorf("expected tombstone for shredded subject, got %v", e.ReadEvent))
+		return
+	}
+	e = <-s
+	if e.Shredded || e.Data.Name != "keep_me" {
+		t.Error(fmt.Errorf("expected readable event, got %v", e.ReadEvent))
+		return
+	}
+	if _, err = ks.Key("forget_me"); !errors.Is(err, keystore.SubjectShreddedError) {
+		t.Error(fmt.Errorf("missmatch error, reading created a key for the shredded subject, %v", err))
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	ctx, cancel := context.WithCancel(ctxGlobal)
+	defer cancel()
+	pers, err := inmemory.Init(STREAM_NAME+"_rotation", ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ring := crypto.NewKeyRing()
+	oldId, err := ring.Rotate(crypto.DefaultSubject)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	c, err := New[dd](pers, cryptKeyProvider, ctx, stream.WithKeyRing(ring))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	readEventStream, err := c.Stream(event.AllTypes(), store.STREAM_START, stream.ReadAll(), ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	go func() {
+		for e := range readEventStream {
+			e.Acc()
+		}
+	}()
+	write := func(i int, t event.Type) error {
+		we := event.NewWriteEvent(event.Event[dd]{
+			Type: t,
+			Data: dd{
+				Id:   i,
+				Name: fmt.Sprintf("rotation_%d", i),
+			},
+			Metadata: event.Metadata{
+				Key: fmt.Sprintf("key_%d", i%2),
+			},
+		})
+		c.Write() <- we
+		return (<-we.Done()).Error
+	}
+	for i := 0; i < 2; i++ {
+		err = write(i, event.Created)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	_, err = ring.Rotate(crypto.DefaultSubject)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for i := 2; i < 4; i++ {
+		err = write(i, event.Updated)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	err = write(5, event.Deleted)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s, err := c.Stream(event.AllTypes(), store.STREAM_START, stream.ReadAll(), ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for i := 0; i < 4; i++ {
+		e := <-s
+		if e.Data.Id != i {
+			t.Errorf("event data missmatch %d != %d", e.Data.Id, i)
+			return
+		}
+	}
+
+	dst, err := inmemory.Init(STREAM_NAME+"_rotation_compacted", ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	n, err := ReEncrypt(pers, dst, cryptKeyProvider, true, ctx, stream.WithKeyRing(ring))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if n != 1 {
+		t.Errorf("compacted event count missmatch %d != 1", n)
+		return
+	}
+	err = ring.Remove(crypto.DefaultSubject, oldId)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	cc, err := New[dd](dst, nil, ctx, stream.WithKeyRing(ring))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s, err = cc.Stream(event.AllTypes(), store.STREAM_START, stream.ReadAll(), ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	e := <-s
+	if e.Data.Id != 2 || e.Metadata.Key != "key_0" || e.Metadata.Stream != dst.Name() {
+		t.Errorf("compacted event missmatch %v", e.ReadEvent)
+	}
+}
+
+func TestReEncryptUnreadableLastEvent(t *testing.T) {
+	ctx, cancel := context.WithTimeout(ctxGlobal, 5*time.Second)
+	defer cancel()
+	pers, err := inmemory.Init(STREAM_NAME+"_reencrypt_unreadable", ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	c, err := New[dd](pers, cryptKeyProvider, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s, err := c.Stream(event.AllTypes(), store.STREAM_START, stream.ReadAll(), ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	go func() {
+		for e := range s {
+			e.Acc()
+		}
+	}()
+	we := event.NewWriteEvent(event.Event[dd]{
+		Type: event.Created,
+		Data: dd{
+			Id: 1,
+		},
+	})
+	c.Write() <- we
+	if status := <-we.Done(); status.Error != nil {
+		t.Error(status.Error)
+		return
+	}
+	status := make(chan store.WriteStatus, 1)
+	pers.Write() <- store.WriteEvent{
+		Event: store.Event{
+			Id:       uuid.Must(uuid.NewV7()),
+			Type:     string(event.Created),
+			Data:     []byte("null"),
+			Metadata: []byte("not metadata"),
+		},
+		Status: status,
+	}
+	if ws := <-status; ws.Error != nil {
+		t.Error(ws.Error)
+		return
+	}
+	dst, err := inmemory.Init(STREAM_NAME+"_reencrypt_unreadable_dst", ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	n, err := RehashKeys[dd](pers, dst, cryptKeyProvider, func(data dd) string {
+		return fmt.Sprint(data.Id)
+	}, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if n != 1 {
+		t.Errorf("missmatch copied events, %d != 1", n)
+	}
+}
+
+func TestAssociatedDataBinding(t *testing.T) {
+	ctx, cancel := context.WithCancel(ctxGlobal)
+	defer cancel()
+	pers, err := inmemory.Init(STREAM_NAME+"_aad", ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	c, err := New[dd](pers, cryptKeyProvider, ctx, stream.WithCipher(crypto.ChaCha20Poly1305))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	readEventStream, err := c.Stream(event.AllTypes(), store.STREAM_START, stream.ReadAll(), ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	go func() {
+		for e := range readEventStream {
+			e.Acc()
+		}
+	}()
+	for i, key := range []string{"victim", "attacker"} {
+		we := event.NewWriteEvent(event.Event[dd]{
+			Type: event.Created,
+			Data: dd{
+				Id:   i,
+				Name: key,
+			},
+			Metadata: event.Metadata{
+				Key: key,
+			},
+		})
+		c.Write() <- we
+		status := <-we.Done()
+		if status.Error != nil {
+			t.Error(status.Error)
+			return
+		}
+	}
+	raw, err := stream.Init[[]byte](pers, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	rawStream, err := raw.Stream(event.AllTypes(), store.STREAM_START, stream.ReadAll(), ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	victim := <-rawStream
+	attacker := <-rawStream
+	e, err := DecryptEvent[dd](victim, cryptKeyProvider)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if e.Data.Name != "victim" {
+		t.Error(fmt.Errorf("missmatch event data, %v", e.Data))
+		return
+	}
+	attacker.Data = victim.Data
+	_, err = DecryptEvent[dd](attacker, cryptKeyProvider)
+	if err == nil {
+		t.Error("payload copied to another event decrypted")
+		return
+	}
+	_, err = DecryptEvent[dd](victim, cryptKeyProvider, stream.WithRequireAAD())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	legacy := victim
+	legacy.Data, err = crypto.Encrypt([]byte(`{"id":0,"name":"victim"}`), testCryptKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = DecryptEvent[dd](legacy, cryptKeyProvider)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = DecryptEvent[dd](legacy, cryptKeyProvider, stream.WithRequireAAD())
+	if !errors.Is(err, MissingAADError) {
+		t.Errorf("missmatch legacy payload error with associated data required, %v", err)
+		return
+	}
+	victim.Metadata.Stream = "other_stream"
+	_, err = DecryptEvent[dd](victim, cryptKeyProvider)
+	if err == nil {
+		t.Error("payload decrypted in another stream")
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(ctxGlobal)
+	defer cancel()
+	pers, err := inmemory.Init(STREAM_NAME+"_read_errors", ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	dl, err := inmemory.Init(STREAM_NAME+"_read_errors_dead_letter", ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	otherKey, err := crypto.GenKey()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	other, err := New[dd](pers, stream.StaticProvider(otherKey), ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	readErrors := make(chan stream.ReadError, 1)
+	c, err := New[dd](pers, cryptKeyProvider, ctx, stream.WithErrorHandler(func(err stream.ReadError) {
+		readErrors <- err
+	}), stream.WithDeadLetter(dl))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	otherStream, err := other.Stream(event.AllTypes(), store.STREAM_START, stream.ReadAll(), ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	go func() {
+		for e := range otherStream {
+			e.Acc()
+		}
+	}()
+	readEventStream, err := c.Stream(event.AllTypes(), store.STREAM_START, stream.ReadAll(), ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var writes []event.WriteEventReadStatus[dd]
+	for i, w := range []Consumer[dd]{other, c} {
+		we := event.NewWriteEvent(event.Event[dd]{
+			Type: event.Created,
+			Data: dd{
+				Id: i,
+			},
+			Metadata: event.Metadata{
+				DataType: "dd",
+			},
+		})
+		w.Write() <- we
+		writes = append(writes, we)
+	}
+	e := <-readEventStream
+	if e.Position != 2 || e.Data.Id != 1 {
+		t.Errorf("missmatch first readable event, %v", e.ReadEvent)
+		return
+	}
+	e.Acc()
+	for _, we := range writes {
+		status := <-we.Done()
+		if status.Error != nil {
+			t.Error(status.Error)
+			return
+		}
+	}
+	re := <-readErrors
+	if re.Stage != stream.StageData || re.Event.Position != 1 || re.Err == nil {
+		t.Errorf("missmatch read error, %v", re)
+		return
+	}
+	stored, err := pers.Stream(store.STREAM_START, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	se := <-stored
+	if string(re.Event.Data) != string(se.Data) {
+		t.Errorf("missmatch read error data, %s != %s", re.Event.Data, se.Data)
+	}
+	dead, err := dl.Stream(store.STREAM_START, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	de := <-dead
+	if de.Id != se.Id || string(de.Data) != string(se.Data) {
+		t.Errorf("missmatch dead-letter event, %v", de)
+	}
+	var metadata event.Metadata
+	err = json.Unmarshal(de.Metadata, &metadata)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if metadata.Stream != pers.Name() || metadata.Extra[stream.DeadLetterStage] != string(stream.StageData) {
+		t.Errorf("missmatch dead-letter metadata, %v", metadata)
+	}
+}
+
+func TestRouter(t *testing.T) {
+	ctx, cancel := context.WithCancel(ctxGlobal)
+	defer cancel()
+	pers, err := inmemory.Init(STREAM_NAME+"_router", ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ddConsumer, err := New[dd](pers, cryptKeyProvider, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	stringConsumer, err := New[string](pers, cryptKeyProvider, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	handled := make(chan string, 4)
+	r, err := NewRouter(pers, cryptKeyProvider, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	Route(r, "dd", func(e event.ReadEvent[dd]) {
+		handled <- e.Data.Name
+	})
+	Route(r, "string", func(e event.ReadEvent[string]) {
+		handled <- e.Data
+	})
+	err = r.Stream(event.AllTypes(), store.STREAM_START, nil, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ddStream, err := ddConsumer.Stream(event.AllTypes(), store.STREAM_START, stream.ReadAll(), ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	go func() {
+		for e := range ddStream {
+			e.Acc()
+		}
+	}()
+	s, err := stringConsumer.Stream(event.AllTypes(), store.STREAM_START, stream.ReadAll(), ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	go func() {
+		for e := range s {
+			e.Acc()
+		}
+	}()
+	for i := 0; i < 2; i++ {
+		we := event.NewWriteEvent(event.Event[dd]{
+			Type: event.Created,
+			Data: dd{
+				Name: fmt.Sprintf("dd %d", i),
+			},
+			Metadata: event.Metadata{
+				DataType: "dd",
+			},
+		})
+		ddConsumer.Write() <- we
+		if status := <-we.Done(); status.Error != nil {
+			t.Error(status.Error)
+			return
+		}
+		swe := event.NewWriteEvent(event.Event[string]{
+			Type: event.Created,
+			Data: fmt.Sprintf("string %d", i),
+			Metadata: event.Metadata{
+				DataType: "string",
+			},
+		})
+		stringConsumer.Write() <- swe
+		if status := <-swe.Done(); status.Error != nil {
+			t.Error(status.Error)
+			return
+		}
+	}
+	for _, expected := range []string{"dd 0", "string 0", "dd 1", "string 1"} {
+		h := <-handled
+		if h != expected {
+			t.Errorf("missmatch routed event, %q != %q", h, expected)
+		}
+	}
+}
+
+func TestProjection(t *testing.T) {
+	ctx, cancel := context.WithCancel(ctxGlobal)
+	defer cancel()
+	src, err := inmemory.Init(STREAM_NAME+"_projection_source", ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	c, err := New[dd](src, cryptKeyProvider, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s, err := c.Stream(event.AllTypes(), store.STREAM_START, stream.ReadAll(), ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	go func() {
+		for e := range s {
+			e.Acc()
+		}
+	}()
+	write := func(i int, dataType string) error {
+		we := event.NewWriteEvent(event.Event[dd]{
+			Type: event.Created,
+			Data: dd{
+				Id:   i,
+				Name: fmt.Sprintf("projection_%d", i%2),
+			},
+			Metadata: event.Metadata{
+				DataType: dataType,
+				Key:      fmt.Sprint(i),
+			},
+		})
+		c.Write() <- we
+		return (<-we.Done()).Error
+	}
+	for i := 1; i <= 4; i++ {
+		dataType := "dd"
+		if i == 2 {
+			dataType = "other"
+		}
+		err = write(i, dataType)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	byName := func(e event.ReadEvent[dd]) string {
+		return e.Data.Name
+	}
+	checkpoints := checkpoint.NewMemory()
+	copies, err := inmemory.Init(STREAM_NAME+"_projection_copies", ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	links, err := inmemory.Init(STREAM_NAME+"_projection_links", ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	projections := []Projection{
+		{Name: "copies", Source: src, Target: copies, Selector: stream.SelectDataTypes("dd"), CryptoKey: cryptKeyProvider, Checkpoints: checkpoints},
+		{Name: "links", Source: src, Target: links, Selector: stream.SelectDataTypes("dd"), CryptoKey: cryptKeyProvider, Checkpoints: checkpoints, Link: true},
+	}
+	run := func() (context.CancelFunc, error) {
+		pctx, pcancel := context.WithCancel(ctx)
+		for _, p := range projections {
+			err := ProjectKeyed(p, byName, pctx)
+			if err != nil {
+				pcancel()
+				return nil, err
+			}
+		}
+		return pcancel, nil
+	}
+	waitFor := func(st stream.Stream, end uint64) bool {
+		for i := 0; i < 100; i++ {
+			pos, err := st.End()
+			if err == nil && pos >= end {
+				time.Sleep(20 * time.Millisecond)
+				pos, _ = st.End()
+				if pos != end {
+					t.Errorf("missmatch %s end, %d != %d", st.Name(), pos, end)
+					return false
+				}
+				return true
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Errorf("timed out waiting for %s to reach %d", st.Name(), end)
+		return false
+	}
+	pcancel, err := run()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !waitFor(copies, 3) || !waitFor(links, 3) {
+		pcancel()
+		return
+	}
+	pcancel()
+
+	err = write(5, "dd")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = checkpoints.Save("copies", 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	pcancel, err = run()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer pcancel()
+	if !waitFor(copies, 4) || !waitFor(links, 4) {
+		return
+	}
+
+	cc, err := New[dd](copies, cryptKeyProvider, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	copied, err := cc.Stream(event.AllTypes(), store.STREAM_START, stream.ReadAll(), ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	linked, err := ReadLinks[dd](links, src, cryptKeyProvider, store.STREAM_START, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for i, id := range []int{1, 3, 4, 5} {
+		e := <-copied
+		e.Acc()
+		if e.Data.Id != id || e.Metadata.Key != crypto.KeyHash(byName(e.ReadEvent)) || e.Metadata.Stream != copies.Name() || e.Metadata.Extra[ProjectionSource] != src.Name() {
+			t.Errorf("missmatch copied event %d, %v", i, e.ReadEvent)
+		}
+		l := <-linked
+		if l.Data.Id != id || l.Metadata.Key != crypto.KeyHash(byName(l)) || l.Position != uint64(i+1) {
+			t.Errorf("missmatch linked event %d, %v", i, l)
+		}
+	}
+}
+
+func TestSubscription(t *testing.T) {
+	ctx, cancel := context.WithCancel(ctxGlobal)
+	defer cancel()
+	pers, err := inmemory.Init(STREAM_NAME+"_subscription", ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	c, err := New[dd](pers, cryptKeyProvider, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	checkpoints, err := checkpoint.NewFile(t.TempDir())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	sctx, scancel := context.WithCancel(ctx)
+	defer scancel()
+	s, err := c.Subscribe("subscriber", checkpoints, store.Selector{}, stream.ReadAll(), sctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	write := func(i int) event.WriteEventReadStatus[dd] {
+		we := event.NewWriteEvent(event.Event[dd]{
+			Type: event.Created,
+			Data: dd{
+				Id: i,
+			},
+		})
+		c.Write() <- we
+		return we
+	}
+	var writes []event.WriteEventReadStatus[dd]
+	for i := 1; i <= 3; i++ {
+		writes = append(writes, write(i))
+	}
+	var received []event.ReadEventWAcc[dd]
+	for i := 1; i <= 3; i++ {
+		e := <-s
+		if e.Data.Id != i {
+			t.Errorf("missmatch subscribed event, %d != %d", e.Data.Id, i)
+			return
+		}
+		received = append(received, e)
+	}
+	checkpointAt := func(expected uint64) {
+		pos, err := checkpoints.Load("subscriber")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if pos != expected {
+			t.Errorf("missmatch subscription checkpoint, %d != %d", pos, expected)
+		}
+	}
+	received[0].Acc()
+	received[2].Acc()
+	checkpointAt(1)
+	received[1].Acc()
+	checkpointAt(3)
+	for _, we := range writes {
+		if status := <-we.Done(); status.Error != nil {
+			t.Error(status.Error)
+			return
+		}
+	}
+	scancel()
+
+	we := write(4)
+	s, err = c.Subscribe("subscriber", checkpoints, store.Selector{}, stream.ReadAll(), ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	e := <-s
+	e.Acc()
+	if e.Data.Id != 4 {
+		t.Errorf("missmatch resumed subscription event, %d != 4", e.Data.Id)
+	}
+	if status := <-we.Done(); status.Error != nil {
+		t.Error(status.Error)
+	}
+	checkpointAt(4)
+}
+
+func TestBatches(t *testing.T) {
+	ctx, cancel := context.WithCancel(ctxGlobal)
+	defer cancel()
+	pers, err := inmemory.Init(STREAM_NAME+"_batches", ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	c, err := New[dd](pers, cryptKeyProvider, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = c.StreamBatches(store.Selector{}, store.STREAM_START, stream.ReadAll(), 0, time.Second, ctx)
+	if !errors.Is(err, InvalidBatchSizeError) {
+		t.Errorf("missmatch error, %v", err)
+	}
+	checkpoints := checkpoint.NewMemory()
+	s, err := c.SubscribeBatches("batches", checkpoints, store.Selector{}, stream.ReadAll(), 2, 50*time.Millisecond, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var writes []event.WriteEventReadStatus[dd]
+	for i := 1; i <= 5; i++ {
+		we := event.NewWriteEvent(event.Event[dd]{
+			Type: event.Created,
+			Data: dd{
+				Id: i,
+			},
+		})
+		c.Write() <- we
+		writes = append(writes, we)
+	}
+	for _, expected := range [][]int{{1, 2}, {3, 4}, {5}} {
+		var b event.ReadBatchWAcc[dd]
+		select {
+		case b = <-s:
+		case <-time.After(time.Second):
+			t.Errorf("timed out waiting for batch %v", expected)
+			return
+		}
+		var ids []int
+		for _, e := range b.Events {
+			ids = append(ids, e.Data.Id)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(expected) {
+			t.Errorf("missmatch batch, %v != %v", ids, expected)
+		}
+		b.Acc()
+	}
+	for _, we := range writes {
+		if status := <-we.Done(); status.Error != nil {
+			t.Error(status.Error)
+			return
+		}
+	}
+	pos, err := checkpoints.Load("batches")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if pos != 5 {
+		t.Errorf("missmatch batch subscription checkpoint, %d != 5", pos)
+	}
+}
+
+func TestSubscriptionAckCheckpointed(t *testing.T) {
+	s := newSubscription("ack_checkpointed", checkpoint.NewMemory(), 2)
+	s.ack(1)
+	s.deliver(3)
+	s.ack(3)
+	s.ack(3)
+	s.ack(2)
+	if s.position != 3 {
+		t.Errorf("missmatch subscription position, %d != 3", s.position)
+	}
+	if len(s.acked) != 0 {
+		t.Errorf("missmatch acked positions kept after checkpointing, %v", s.acked)
+	}
+}
+
+func TestBatchNack(t *testing.T) {
+	ctx, cancel := context.WithCancel(ctxGlobal)
+	defer cancel()
+	pers, err := inmemory.Init(STREAM_NAME+"_batch_nack", ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	dl, err := inmemory.Init(STREAM_NAME+"_batch_nack_dead_letter", ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	c, err := New[dd](pers, cryptKeyProvider, ctx, stream.WithDeadLetter(dl), stream.WithRetry(stream.RetryPolicy{Attempts: 2, Backoff: 10 * time.Millisecond}))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	checkpoints := checkpoint.NewMemory()
+	s, err := c.SubscribeBatches("batch_nack", checkpoints, store.Selector{}, stream.ReadAll(), 2, 50*time.Millisecond, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var writes []event.WriteEventReadStatus[dd]
+	for i := 1; i <= 2; i++ {
+		we := event.NewWriteEvent(event.Event[dd]{
+			Type: event.Created,
+			Data: dd{
+				Id: i,
+			},
+		})
+		c.Write() <- we
+		writes = append(writes, we)
+	}
+	handlerErr := errors.New("batch handler failed")
+	for attempt := 1; attempt <= 2; attempt++ {
+		var b event.ReadBatchWAcc[dd]
+		select {
+		case b = <-s:
+		case <-time.After(time.Second):
+			t.Errorf("timed out waiting for attempt %d", attempt)
+			return
+		}
+		if len(b.Events) != 2 || b.Events[0].Data.Id != 1 || b.Events[1].Data.Id != 2 {
+			t.Errorf("missmatch batch on attempt %d, %v", attempt, b.Events)
+			return
+		}
+		b.Nack(handlerErr)
+	}
+	for _, we := range writes {
+		if status := <-we.Done(); status.Error != nil {
+			t.Error(status.Error)
+			return
+		}
+	}
+	pos, err := checkpoints.Load("batch_nack")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if pos != 2 {
+		t.Errorf("missmatch checkpoint after parking the batch, %d != 2", pos)
+	}
+	parked, err := dl.Stream(store.STREAM_START, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, expected := range []uint64{1, 2} {
+		e := <-parked
+		if e.Position != expected {
+			t.Errorf("missmatch parked event position, %d != %d", e.Position, expected)
+			return
+		}
+	}
+}
+
+func TestNack(t *testing.T) {
+	ctx, cancel := context.WithCancel(ctxGlobal)
+	defer cancel()
+	pers, err := inmemory.Init(STREAM_NAME+"_nack", ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	dl, err := inmemory.Init(STREAM_NAME+"_nack_dead_letter", ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	c, err := New[dd](pers, cryptKeyProvider, ctx, stream.WithDeadLetter(dl), stream.WithRetry(stream.RetryPolicy{Attempts: 3, Backoff: 10 * time.Millisecond}))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	checkpoints := checkpoint.NewMemory()
+	s, err := c.Subscribe("nack", checkpoints, store.Selector{}, stream.ReadAll(), ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var writes []event.WriteEventReadStatus[dd]
+	for i := 1; i <= 2; i++ {
+		we := event.NewWriteEvent(event.Event[dd]{
+			Type: event.Created,
+			Data: dd{
+				Id: i,
+			},
+		})
+		c.Write() <- we
+		writes = append(writes, we)
+	}
+	handlerErr := errors.New("handler failed")
+	for _, expected := range []int{1, 2, 1, 1} {
+		var e event.ReadEventWAcc[dd]
+		select {
+		case e = <-s:
+		case <-time.After(time.Second):
+			t.Errorf("timed out waiting for event %d", expected)
+			return
+		}
+		if e.Data.Id != expected {
+			t.Errorf("missmatch delivered event, %d != %d", e.Data.Id, expected)
+			return
+		}
+		if e.Data.Id == 2 {
+			e.Acc()
+			continue
+		}
+		e.Nack(handlerErr)
+	}
+	for _, we := range writes {
+		if status := <-we.Done(); status.Error != nil {
+			t.Error(status.Error)
+			return
+		}
+	}
+	pos, err := checkpoints.Load("nack")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if pos != 2 {
+		t.Errorf("missmatch checkpoint after parking, %d != 2", pos)
+	}
+	parked, err := dl.Stream(store.STREAM_START, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	e := <-parked
+	var md event.Metadata
+	err = json.Unmarshal(e.Metadata, &md)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if e.Position != 1 || md.Extra[stream.DeadLetterStage] != string(stream.StageHandler) || md.Extra[stream.DeadLetterError] != handlerErr.Error() {
+		t.Errorf("missmatch parked event, %d %v", e.Position, md.Extra)
+	}
+	if d := (stream.RetryPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second}).Delay(4); d != 5*time.Second {
+		t.Errorf("missmatch capped delay, %s != 5s", d)
+	}
+}
+
+func TestParallel(t *testing.T) {
+	ctx, cancel := context.WithCancel(ctxGlobal)
+	defer cancel()
+	pers, err := inmemory.Init(STREAM_NAME+"_parallel", ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	c, err := New[dd](pers, cryptKeyProvider, ctx, stream.WithRetry(stream.RetryPolicy{Attempts: 2, Backoff: time.Millisecond}))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	checkpoints := checkpoint.NewMemory()
+	release := make(chan struct{})
+	failed := false
+	var lock sync.Mutex
+	handled := make(map[string][]int)
+	byName := func(e event.ReadEvent[dd]) string {
+		return e.Data.Name
+	}
+	err = c.SubscribeParallel("parallel", checkpoints, store.Selector{}, stream.ReadAll(), 3, byName, func(e event.ReadEvent[dd], _ context.Context) error {
+		if e.Data.Name == "slow" {
+			<-release
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		if e.Data.Id == 5 && !failed {
+			failed = true
+			return fmt.Errorf("failing %d once", e.Data.Id)
+		}
+		handled[e.Data.Name] = append(handled[e.Data.Name], e.Data.Id)
+		return nil
+	}, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for i := 1; i <= 9; i++ {
+		name := []string{"a", "b", "c"}[i%3]
+		if i == 1 {
+			name = "slow"
+		}
+		c.Write() <- event.NewWriteEvent(event.Event[dd]{
+			Type: event.Created,
+			Data: dd{
+				Id:   i,
+				Name: name,
+			},
+		})
+	}
+	time.Sleep(100 * time.Millisecond)
+	pos, err := checkpoints.Load("parallel")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if pos != 0 {
+		t.Errorf("missmatch checkpoint while the first event is handled, %d != 0", pos)
+	}
+	close(release)
+	for i := 0; i < 100 && pos != 9; i++ {
+		time.Sleep(10 * time.Millisecond)
+		pos, err = checkpoints.Load("parallel")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if pos != 9 {
+		t.Errorf("missmatch checkpoint, %d != 9", pos)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if fmt.Sprint(handled) != "map[a:[3 6 9] b:[4 7] c:[2 5 8] slow:[1]]" {
+		t.Errorf("missmatch handled events per key, %v", handled)
+	}
+	err = c.SubscribeParallel("parallel", checkpoints, store.Selector{}, stream.ReadAll(), 0, nil, nil, ctx)
+	if !errors.Is(err, InvalidWorkerCountError) {
+		t.Errorf("missmatch error, %v", err)
+	}
+}
+
 func TestTairdown(t *testing.T) {
 	ctxGlobalCancel()
 }
@@ -2,8 +2,10 @@ package consumer
 
 import (
 	"context"
+	"time"
 
 	"github.com/cantara/gober/stream"
+	"github.com/cantara/gober/stream/checkpoint"
 	"github.com/cantara/gober/stream/event"
 	"github.com/cantara/gober/stream/event/store"
 )
@@ -13,5 +15,21 @@ type Consumer[T any] interface {
 	Stream(eventTypes []event.Type, from store.StreamPosition, filter stream.Filter, ctx context.Context) (out <-chan event.ReadEventWAcc[T], err error)
 	Name() string
 	End() (pos uint64, err error)
+	// Deprecated: FilteredEnd scans the stream on every call, use CachedFilteredEnd or SelectedEnd of SelectedConsumer.
 	FilteredEnd(eventTypes []event.Type, filter stream.Filter) (pos uint64, err error)
 }
+
+// SelectedConsumer is a Consumer that also reads events by selector, in subscriptions and batches, waits for its writes
+// to be read and keeps filtered ends up to date. It is kept apart from Consumer so implementations of that do not have
+// to change. New returns one.
+type SelectedConsumer[T any] interface {
+	Consumer[T]
+	StreamSelected(sel store.Selector, from store.StreamPosition, filter stream.Filter, ctx context.Context) (out <-chan event.ReadEventWAcc[T], err error)
+	Subscribe(name string, checkpoints checkpoint.Store, sel store.Selector, filter stream.Filter, ctx context.Context) (out <-chan event.ReadEventWAcc[T], err error)
+	SubscribeParallel(name string, checkpoints checkpoint.Store, sel store.Selector, filter stream.Filter, workers int, key func(e event.ReadEvent[T]) string, handle func(e event.ReadEvent[T], ctx context.Context) error, ctx context.Context) (err error)
+	StreamBatches(sel store.Selector, from store.StreamPosition, filter stream.Filter, size int, linger time.Duration, ctx context.Context) (out <-chan event.ReadBatchWAcc[T], err error)
+	SubscribeBatches(name string, checkpoints checkpoint.Store, sel store.Selector, filter stream.Filter, size int, linger time.Duration, ctx context.Context) (out <-chan event.ReadBatchWAcc[T], err error)
+	WaitForPosition(ctx context.Context, pos uint64) (err error)
+	CachedFilteredEnd(eventTypes []event.Type, filterKey string, filter stream.Filter) (pos uint64, err error)
+	SelectedEnd(sel store.Selector, filter stream.Filter) (pos uint64, err error)
+}
@@ -0,0 +1,93 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	log "github.com/cantara/bragi/sbragi"
+	"github.com/cantara/gober/stream"
+	"github.com/cantara/gober/stream/checkpoint"
+	"github.com/cantara/gober/stream/event"
+	"github.com/cantara/gober/stream/event/store"
+)
+
+var InvalidWorkerCountError = errors.New("worker count has to be at least 1")
+
+// workerQueueSize is the number of events queued per worker, so a slow key only holds back the keys of its worker
+// once its queue is full.
+const workerQueueSize = 64
+
+// SubscribeParallel handles the selected events of the subscription name with workers goroutines in the background.
+// Events are spread over the workers by the hash of their key, the metadata key unless key is given, so the events of
+// a key are handled in order by the same worker. Events handle returns an error for are retried in place by the retry
+// policy of the stream, keeping the order of their key, and parked when their attempts are used up. The checkpoint only
+// advances over positions where every earlier event is handled, whichever worker handled it.
+func (c *consumer[T]) SubscribeParallel(name string, checkpoints checkpoint.Store, sel store.Selector, filter stream.Filter, workers int, key func(e event.ReadEvent[T]) string, handle func(e event.ReadEvent[T], ctx context.Context) error, ctx context.Context) (err error) {
+	if workers < 1 {
+		err = fmt.Errorf("workers %d, error:%w", workers, InvalidWorkerCountError)
+		return
+	}
+	if key == nil {
+		key = func(e event.ReadEvent[T]) string {
+			return e.Metadata.Key
+		}
+	}
+	s, mctx, err := c.subscribe(name, checkpoints, sel, filter, ctx)
+	if err != nil {
+		return
+	}
+	queues := make([]chan event.ReadEventWAcc[T], workers)
+	for i := range queues {
+		queues[i] = make(chan event.ReadEventWAcc[T], workerQueueSize)
+		go c.work(queues[i], handle, mctx)
+	}
+	go func() {
+		for {
+			select {
+			case <-mctx.Done():
+				return
+			case e := <-s:
+				h := fnv.New32a()
+				h.Write([]byte(key(e.ReadEvent)))
+				select {
+				case <-mctx.Done():
+					return
+				case queues[h.Sum32()%uint32(workers)] <- e:
+				}
+			}
+		}
+	}()
+	return
+}
+
+func (c *consumer[T]) work(queue <-chan event.ReadEventWAcc[T], handle func(e event.ReadEvent[T], ctx context.Context) error, ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-queue:
+			for n := 1; ; n++ {
+				err := handle(e.ReadEvent, e.CTX)
+				if err == nil {
+					e.Acc()
+					break
+				}
+				if n >= c.opts.Retry.Attempts {
+					e.Nack(err)
+					e.Acc()
+					break
+				}
+				delay := c.opts.Retry.Delay(n)
+				log.WithError(err).Debug("retrying failed event", "stream", c.stream.Name(), "position", e.Position, "attempt", n, "delay", delay)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(delay):
+				}
+			}
+		}
+	}
+}
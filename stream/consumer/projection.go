@@ -0,0 +1,341 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	log "github.com/cantara/bragi/sbragi"
+	"github.com/cantara/gober/crypto"
+	"github.com/cantara/gober/stream"
+	"github.com/cantara/gober/stream/checkpoint"
+	"github.com/cantara/gober/stream/event"
+	"github.com/cantara/gober/stream/event/store"
+)
+
+// Extra metadata keys of projected events, referring back to the source event.
+const (
+	ProjectionSource   = "projection_source"
+	ProjectionPosition = "projection_position"
+	ProjectionId       = "projection_id"
+	ProjectionLink     = "projection_link"
+)
+
+var NotALinkError = errors.New("event is not a projection link")
+var LinkMissmatchError = errors.New("linked event not found in source")
+
+// Projection describes a derived stream, the events of Source selected by Selector and Filter written to Target.
+// The events are either copied, with the data re-encrypted for the target stream, or written as links, events without
+// data referring to the source event, that are resolved with ReadLinks.
+// The position in Source is saved under Name in Checkpoints.
+type Projection struct {
+	Name        string
+	Source      stream.Stream
+	Target      stream.Stream
+	Selector    store.Selector
+	Filter      stream.Filter
+	Link        bool
+	CryptoKey   stream.CryptoKeyProvider
+	Checkpoints checkpoint.Store
+	Options     []stream.Option
+}
+
+// Project maintains the projection in the background until ctx is done, continuing from the checkpoint of the
+// projection or the last event it wrote to the target, whichever is later, so a restart neither skips nor duplicates
+// events. Events that can not be decrypted are reported to the error handler in the options and skipped, events with
+// shredded keys are skipped, and failing writes are retried.
+func Project(p Projection, ctx context.Context) (err error) {
+	return project(p, nil, ctx)
+}
+
+// ProjectKeyed maintains the projection like Project, with the metadata key of the projected events set to the hash, by
+// crypto.KeyHash, of the key returned for the decrypted source event. Events where key returns an empty string keep
+// their metadata key. As the metadata key selects the encryption key, copies are encrypted with the key cryptoKey, or
+// the key ring, gives for the hashed key.
+func ProjectKeyed[T any](p Projection, key func(e event.ReadEvent[T]) string, ctx context.Context) (err error) {
+	o := stream.NewOptions(p.Options...)
+	return project(p, func(e event.ReadEvent[[]byte]) (k string, ok bool, err error) {
+		de, err := decryptEvent[T](e, p.CryptoKey, o)
+		if err != nil || de.Shredded {
+			return
+		}
+		return key(de), true, nil
+	}, ctx)
+}
+
+func project(p Projection, rekey func(e event.ReadEvent[[]byte]) (key string, ok bool, err error), ctx context.Context) (err error) {
+	o := stream.NewOptions(p.Options...)
+	from, err := p.Checkpoints.Load(p.Name)
+	if err != nil {
+		return
+	}
+	projected, err := lastProjected(p.Target, p.Source.Name(), ctx)
+	if err != nil {
+		return
+	}
+	if projected > from {
+		log.Info("continuing projection after the last projected event", "name", p.Name, "checkpoint", from, "projected", projected)
+		from = projected
+	}
+	fs, err := stream.Init[[]byte](p.Source, ctx, p.Options...)
+	if err != nil {
+		return
+	}
+	s, err := fs.StreamSelected(p.Selector, store.StreamPosition(from), p.Filter, ctx)
+	if err != nil {
+		return
+	}
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e, ok := <-s:
+				if !ok {
+					return
+				}
+				se, ok, err := projectedEvent(p, e, rekey, o)
+				if err != nil {
+					o.ReportReadError(stream.ReadError{Stream: p.Source.Name(), Event: StoredEvent(e), Stage: stream.StageData, Err: err}, ctx)
+				} else if ok && !writeProjected(p.Target, se, e.Position, ctx) {
+					return
+				}
+				err = p.Checkpoints.Save(p.Name, e.Position)
+				if err != nil {
+					log.WithError(err).Error("saving projection checkpoint", "name", p.Name, "position", e.Position)
+				}
+			}
+		}
+	}()
+	return
+}
+
+// projectedEvent returns the event to write to the target for the source event, events with shredded keys are not ok.
+func projectedEvent(p Projection, e event.ReadEvent[[]byte], rekey func(e event.ReadEvent[[]byte]) (key string, ok bool, err error), o stream.Options) (se store.Event, ok bool, err error) {
+	key := e.Metadata.Key
+	if rekey != nil {
+		var k string
+		k, ok, err = rekey(e)
+		if err != nil || !ok {
+			return
+		}
+		if k != "" {
+			key = crypto.KeyHash(k)
+		}
+	}
+	refer := func(md *event.Metadata) {
+		extra := make(map[string]any, len(md.Extra)+4)
+		for k, v := range md.Extra {
+			extra[k] = v
+		}
+		extra[ProjectionSource] = p.Source.Name()
+		extra[ProjectionPosition] = e.Position
+		extra[ProjectionId] = e.Id.String()
+		md.Key = key
+		md.Extra = extra
+	}
+	if !p.Link {
+		return reEncrypted(e, p.Target.Name(), p.CryptoKey, func(_ event.ReadEvent[[]byte], md *event.Metadata) error {
+			refer(md)
+			return nil
+		}, o)
+	}
+	md := e.Metadata
+	md.Stream = p.Target.Name()
+	md.Codec = ""
+	md.Compression = ""
+	md.ClaimCheck = ""
+	refer(&md)
+	md.Extra[ProjectionLink] = true
+	se, err = signedEvent(e.Id, e.Type, []byte("null"), md, o)
+	ok = err == nil
+	return
+}
+
+// writeProjected writes the event to target, retrying until it is written or ctx is done.
+func writeProjected(target stream.Stream, se store.Event, from uint64, ctx context.Context) bool {
+	for {
+		err := writeStored(target, se, from, ctx)
+		if err == nil {
+			return true
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+		log.WithError(err).Error("writing projected event, retrying", "stream", target.Name(), "from", from)
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// lastProjected returns the latest source position of the events projected from source in target up to its end.
+func lastProjected(target stream.Stream, source string, ctx context.Context) (pos uint64, err error) {
+	end, err := target.End()
+	if err != nil || end == 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	events, err := target.Stream(store.STREAM_START, ctx)
+	if err != nil {
+		return
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			var md event.Metadata
+			if json.Unmarshal(e.Metadata, &md) == nil && md.Extra[ProjectionSource] == source {
+				if p, ok := extraPosition(md.Extra[ProjectionPosition]); ok && p > pos {
+					pos = p
+				}
+			}
+			if e.Position >= end {
+				return
+			}
+		}
+	}
+}
+
+func extraPosition(v any) (pos uint64, ok bool) {
+	switch p := v.(type) {
+	case float64:
+		return uint64(p), p >= 0
+	case uint64:
+		return p, true
+	case int:
+		return uint64(p), p >= 0
+	case string:
+		n, err := strconv.ParseUint(p, 10, 64)
+		return n, err == nil
+	}
+	return
+}
+
+// ReadLinks streams the events of target, written by a linking projection of source, resolved to the source events they
+// link to and decrypted. The events have the positions of the links, so readers keep their position in target, and the
+// stream, key and extra metadata of the links. Links are resolved in one pass over source, as they are written in
+// source order. Links that can not be resolved are reported to the error handler in opts and skipped.
+func ReadLinks[T any](target, source stream.Stream, cryptoKey stream.CryptoKeyProvider, from store.StreamPosition, ctx context.Context, opts ...stream.Option) (out <-chan event.ReadEvent[T], err error) {
+	o := stream.NewOptions(opts...)
+	tfs, err := stream.Init[[]byte](target, ctx, opts...)
+	if err != nil {
+		return
+	}
+	sfs, err := stream.Init[[]byte](source, ctx, opts...)
+	if err != nil {
+		return
+	}
+	links, err := tfs.Stream(nil, from, stream.ReadAll(), ctx)
+	if err != nil {
+		return
+	}
+	events := make(chan event.ReadEvent[T])
+	out = events
+	go func() {
+		r := linkResolver{source: sfs, ctx: ctx}
+		defer r.close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case link, ok := <-links:
+				if !ok {
+					return
+				}
+				e, err := r.resolve(link)
+				if err != nil {
+					if ctx.Err() != nil {
+						return
+					}
+					o.ReportReadError(stream.ReadError{Stream: target.Name(), Event: StoredEvent(link), Stage: stream.StageData, Err: err}, ctx)
+					continue
+				}
+				de, err := decryptEvent[T](e, cryptoKey, o)
+				if err != nil {
+					o.ReportReadError(stream.ReadError{Stream: target.Name(), Event: StoredEvent(link), Stage: stream.StageData, Err: err}, ctx)
+					continue
+				}
+				de.Position = link.Position
+				de.Metadata.Stream = link.Metadata.Stream
+				de.Metadata.Key = link.Metadata.Key
+				de.Metadata.Extra = link.Metadata.Extra
+				select {
+				case <-ctx.Done():
+					return
+				case events <- de:
+				}
+			}
+		}
+	}()
+	return
+}
+
+// linkResolver reads the source forward to the linked events, reopening it only when a link points backwards.
+type linkResolver struct {
+	source  stream.SelectedStream[[]byte]
+	events  <-chan event.ReadEvent[[]byte]
+	current event.ReadEvent[[]byte]
+	cancel  context.CancelFunc
+	ctx     context.Context
+}
+
+func (r *linkResolver) resolve(link event.ReadEvent[[]byte]) (e event.ReadEvent[[]byte], err error) {
+	if isLink, _ := link.Metadata.Extra[ProjectionLink].(bool); !isLink {
+		err = fmt.Errorf("position %d, error:%w", link.Position, NotALinkError)
+		return
+	}
+	pos, ok := extraPosition(link.Metadata.Extra[ProjectionPosition])
+	if !ok || pos == 0 {
+		err = fmt.Errorf("position %d, error:%w", link.Position, NotALinkError)
+		return
+	}
+	if r.events == nil || r.current.Position >= pos {
+		r.close()
+		ctx, cancel := context.WithCancel(r.ctx)
+		r.events, err = r.source.Stream(nil, store.StreamPosition(pos-1), stream.ReadAll(), ctx)
+		if err != nil {
+			cancel()
+			r.events = nil
+			return
+		}
+		r.cancel = cancel
+		r.current = event.ReadEvent[[]byte]{}
+	}
+	for r.current.Position < pos {
+		select {
+		case <-r.ctx.Done():
+			err = r.ctx.Err()
+			return
+		case r.current, ok = <-r.events:
+			if !ok {
+				r.events = nil
+				err = fmt.Errorf("source position %d, error:%w", pos, LinkMissmatchError)
+				return
+			}
+		}
+	}
+	if r.current.Position != pos || r.current.Id.String() != link.Metadata.Extra[ProjectionId] {
+		err = fmt.Errorf("source position %d, error:%w", pos, LinkMissmatchError)
+		return
+	}
+	e = r.current
+	return
+}
+
+func (r *linkResolver) close() {
+	if r.cancel != nil {
+		r.cancel()
+		r.cancel = nil
+	}
+}
@@ -0,0 +1,265 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	log "github.com/cantara/bragi/sbragi"
+	"github.com/cantara/gober/crypto"
+	"github.com/cantara/gober/stream"
+	"github.com/cantara/gober/stream/event"
+	"github.com/cantara/gober/stream/event/signature"
+	"github.com/cantara/gober/stream/event/store"
+	"github.com/gofrs/uuid"
+)
+
+// ReEncrypt copies the events in src, up to its end when called, to dst with the data re-encrypted under the current
+// key of the key ring in opts. Ciphertexts without a key id are decrypted with cryptoKey. Event ids, types and metadata
+// are kept, except signatures as the data changes, give a signer in opts to sign the copies. With compact only the last
+// event per data type and metadata key is copied, and keys whose last event is a delete are dropped. Events with
+// shredded keys are never copied. The key ring has to keep its keys across restarts, like crypto.NewPersistentKeyRing
+// does, or the copies can not be decrypted once the process stops.
+// ReEncrypt blocks until the copy is done, run it in a goroutine to rewrite a stream in the background.
+func ReEncrypt(src, dst stream.Stream, cryptoKey stream.CryptoKeyProvider, compact bool, ctx context.Context, opts ...stream.Option) (n int, err error) {
+	if stream.NewOptions(opts...).KeyRing == nil {
+		err = MissingKeyRingError
+		return
+	}
+	return copyEvents(src, dst, cryptoKey, compact, nil, ctx, opts...)
+}
+
+// RehashKeys copies the events in src, up to its end when called, to dst with the metadata key replaced by the
+// crypto.KeyHash of the key returned for the event data. Events where key returns an empty string keep their metadata
+// key. It is the migration from SimpleHash to keyed hashes, set the secret with crypto.SetKeyHashSecret before calling it
+// and switch the writers over to dst afterwards. As the metadata key selects the encryption key, the data is
+// re-encrypted with the key cryptoKey, or the key ring, gives for the new metadata key.
+func RehashKeys[T any](src, dst stream.Stream, cryptoKey stream.CryptoKeyProvider, key func(data T) string, ctx context.Context, opts ...stream.Option) (n int, err error) {
+	o := stream.NewOptions(opts...)
+	return copyEvents(src, dst, cryptoKey, false, func(e event.ReadEvent[[]byte], md *event.Metadata) (err error) {
+		de, err := decryptEvent[T](e, cryptoKey, o)
+		if err != nil || de.Shredded {
+			return
+		}
+		if k := key(de.Data); k != "" {
+			md.Key = crypto.KeyHash(k)
+		}
+		return
+	}, ctx, opts...)
+}
+
+// copyEvents copies src to dst re-encrypting the data, rewrite is called with the metadata to write before encrypting.
+func copyEvents(src, dst stream.Stream, cryptoKey stream.CryptoKeyProvider, compact bool, rewrite func(e event.ReadEvent[[]byte], md *event.Metadata) error, ctx context.Context, opts ...stream.Option) (n int, err error) {
+	o := stream.NewOptions(opts...)
+	end, err := src.End()
+	if err != nil || end == 0 {
+		return
+	}
+	rctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// The store is read directly, so the copy stops at end even when the last events can not be read.
+	s, err := src.Stream(store.STREAM_START, rctx)
+	if err != nil {
+		return
+	}
+
+	var events []event.ReadEvent[[]byte]
+	latest := make(map[string]int)
+	for {
+		var se store.ReadEvent
+		var ok bool
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		case se, ok = <-s:
+			if !ok {
+				err = fmt.Errorf("stream %s closed before its end %d, error:%w", src.Name(), end, io.ErrUnexpectedEOF)
+				return
+			}
+		}
+		e, stage, rerr := readStored(se, o)
+		if rerr != nil {
+			o.ReportReadError(stream.ReadError{Stream: src.Name(), Event: se, Stage: stage, Err: rerr}, ctx)
+			if se.Position >= end {
+				break
+			}
+			continue
+		}
+		if !compact {
+			var written bool
+			written, err = reEncryptEvent(e, dst, cryptoKey, rewrite, o, ctx)
+			if err != nil {
+				return
+			}
+			if written {
+				n++
+			}
+		} else {
+			k := e.Metadata.DataType + "/" + e.Metadata.Key
+			if i, ok := latest[k]; ok {
+				events[i].Data = nil
+			}
+			latest[k] = len(events)
+			events = append(events, e)
+		}
+		if e.Position >= end {
+			break
+		}
+	}
+	for i, e := range events {
+		k := e.Metadata.DataType + "/" + e.Metadata.Key
+		if latest[k] != i || e.Type == event.Deleted {
+			continue
+		}
+		var written bool
+		written, err = reEncryptEvent(e, dst, cryptoKey, rewrite, o, ctx)
+		if err != nil {
+			return
+		}
+		if written {
+			n++
+		}
+	}
+	return
+}
+
+// readStored decodes the metadata and raw data of a stored event, verifying its signature like streams do.
+func readStored(se store.ReadEvent, opts stream.Options) (e event.ReadEvent[[]byte], stage stream.ReadStage, err error) {
+	var md event.Metadata
+	err = json.Unmarshal(se.Metadata, &md)
+	if err != nil {
+		return e, stream.StageMetadata, err
+	}
+	verified := false
+	if opts.TrustStore != nil {
+		err = signature.Verify(opts.TrustStore, se.Id, event.Type(se.Type), se.Data, md)
+		if !opts.SignaturePolicy.Accept(err) {
+			return e, stream.StageSignature, err
+		}
+		verified = err == nil
+	}
+	var data []byte
+	err = json.Unmarshal(se.Data, &data)
+	if err != nil {
+		return e, stream.StageData, err
+	}
+	e = event.ReadEvent[[]byte]{
+		Event: event.Event[[]byte]{
+			Id:       se.Id,
+			Type:     event.TypeFromString(se.Type),
+			Data:     data,
+			Metadata: md,
+		},
+		Position: se.Position,
+		Created:  se.Created,
+		Verified: verified,
+	}
+	return e, "", nil
+}
+
+func reEncryptEvent(e event.ReadEvent[[]byte], dst stream.Stream, cryptoKey stream.CryptoKeyProvider, rewrite func(e event.ReadEvent[[]byte], md *event.Metadata) error, opts stream.Options, ctx context.Context) (written bool, err error) {
+	se, ok, err := reEncrypted(e, dst.Name(), cryptoKey, rewrite, opts)
+	if err != nil || !ok {
+		return
+	}
+	err = writeStored(dst, se, e.Position, ctx)
+	written = err == nil
+	return
+}
+
+// reEncrypted returns the event as stored in the stream named dst, with the data re-encrypted for the rewritten
+// metadata. Events with shredded keys are not ok.
+func reEncrypted(e event.ReadEvent[[]byte], dst string, cryptoKey stream.CryptoKeyProvider, rewrite func(e event.ReadEvent[[]byte], md *event.Metadata) error, opts stream.Options) (se store.Event, ok bool, err error) {
+	data, err := resolveClaimCheck(e.Data, e.Metadata, opts)
+	if err != nil {
+		return
+	}
+	data, err = decryptData(data, e.Id, e.Metadata, cryptoKey, opts)
+	if err != nil {
+		if errors.Is(err, crypto.KeyShreddedError) {
+			log.Debug("Skipping event with shredded key while re-encrypting", "position", e.Position)
+			err = nil
+		}
+		return
+	}
+	md := e.Metadata
+	md.Stream = dst
+	if rewrite != nil {
+		err = rewrite(e, &md)
+		if err != nil {
+			return
+		}
+	}
+	data, err = encryptData(data, e.Id, md, cryptoKey, opts)
+	if err != nil {
+		return
+	}
+	md.ClaimCheck = ""
+	if opts.ClaimCheck != nil && len(data) > opts.ClaimCheckThreshold {
+		md.ClaimCheck, err = opts.ClaimCheck.Put(data)
+		if err != nil {
+			return
+		}
+		data = nil
+	}
+	bdata, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	se, err = signedEvent(e.Id, e.Type, bdata, md, opts)
+	ok = err == nil
+	return
+}
+
+// signedEvent marshals the metadata into a stored event, signing it with the signer in opts.
+func signedEvent(id uuid.UUID, t event.Type, data []byte, md event.Metadata, opts stream.Options) (se store.Event, err error) {
+	md.SignerKeyId = ""
+	md.Signature = nil
+	if opts.Signer != nil {
+		err = opts.Signer.Sign(id, t, data, &md)
+		if err != nil {
+			return
+		}
+	}
+	bmd, err := json.Marshal(md)
+	if err != nil {
+		return
+	}
+	se = store.Event{
+		Id:       id,
+		Type:     string(t),
+		Data:     data,
+		Metadata: bmd,
+	}
+	return
+}
+
+// writeStored writes the stored event to dst and waits for it to be written.
+func writeStored(dst stream.Stream, se store.Event, from uint64, ctx context.Context) (err error) {
+	status := make(chan store.WriteStatus, 1)
+	we := store.WriteEvent{
+		Event:  se,
+		Status: status,
+	}
+	select {
+	case <-ctx.Done():
+		err = ctx.Err()
+		return
+	case dst.Write() <- we:
+	}
+	select {
+	case <-ctx.Done():
+		err = ctx.Err()
+		return
+	case s := <-status:
+		if s.Error != nil {
+			err = s.Error
+			return
+		}
+		log.Trace("re-encrypted event", "from", from, "to", s.Position, "time", s.Time.Format(time.RFC3339))
+	}
+	return
+}
@@ -0,0 +1,80 @@
+package consumer
+
+import (
+	"context"
+	"time"
+
+	log "github.com/cantara/bragi/sbragi"
+	"github.com/cantara/gober/mergedcontext"
+	"github.com/cantara/gober/stream/event"
+)
+
+// retrying redelivers the nacked events of in by the retry policy of the stream. Events nacked on their last attempt
+// are parked with the nack they got from the stream and acknowledged, so they do not hold back the events after them.
+// Redelivered events come after the events read while they waited, so they can be out of order.
+func (c *consumer[T]) retrying(in <-chan event.ReadEventWAcc[T], ctx context.Context) <-chan event.ReadEventWAcc[T] {
+	return retrying(c, in, func(e *event.ReadEventWAcc[T]) (acc func(), nack *func(err error), position uint64) {
+		return e.Acc, &e.Nack, e.Position
+	}, ctx)
+}
+
+// retryingBatches redelivers nacked batches like retrying does events, batches nacked on their last attempt have all
+// their events parked.
+func (c *consumer[T]) retryingBatches(in <-chan event.ReadBatchWAcc[T], ctx context.Context) <-chan event.ReadBatchWAcc[T] {
+	return retrying(c, in, func(b *event.ReadBatchWAcc[T]) (acc func(), nack *func(err error), position uint64) {
+		return b.Acc, &b.Nack, b.Events[len(b.Events)-1].Position
+	}, ctx)
+}
+
+// retrying redelivers the nacked deliveries of in, events or batches, by the retry policy of the stream. fields returns
+// the acknowledgement, the nack to replace and the position of a delivery.
+func retrying[T, E any](c *consumer[T], in <-chan E, fields func(e *E) (acc func(), nack *func(err error), position uint64), ctx context.Context) <-chan E {
+	mctx, cancel := mergedcontext.MergeContexts(c.ctx, ctx)
+	out := make(chan E, 0)
+	redeliveries := make(chan E, 0)
+	var attempt func(e E, park func(err error), n int) E
+	attempt = func(e E, park func(err error), n int) E {
+		acc, nack, position := fields(&e)
+		*nack = func(err error) {
+			if n >= c.opts.Retry.Attempts {
+				park(err)
+				acc()
+				return
+			}
+			delay := c.opts.Retry.Delay(n)
+			log.WithError(err).Debug("redelivering nacked event", "stream", c.stream.Name(), "position", position, "attempt", n, "delay", delay)
+			go func() {
+				select {
+				case <-mctx.Done():
+					return
+				case <-time.After(delay):
+				}
+				select {
+				case <-mctx.Done():
+				case redeliveries <- attempt(e, park, n+1):
+				}
+			}()
+		}
+		return e
+	}
+	go func() {
+		defer cancel()
+		for {
+			var e E
+			select {
+			case <-mctx.Done():
+				return
+			case e = <-in:
+				_, nack, _ := fields(&e)
+				e = attempt(e, *nack, 1)
+			case e = <-redeliveries:
+			}
+			select {
+			case <-mctx.Done():
+				return
+			case out <- e:
+			}
+		}
+	}()
+	return out
+}
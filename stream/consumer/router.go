@@ -0,0 +1,85 @@
+package consumer
+
+import (
+	"context"
+
+	"github.com/cantara/gober/mergedcontext"
+	"github.com/cantara/gober/stream"
+	"github.com/cantara/gober/stream/event"
+	"github.com/cantara/gober/stream/event/store"
+)
+
+// Router is stream.Router for streams written by consumers, events are decrypted into the Go type of their handler.
+type Router struct {
+	stream    stream.SelectedStream[[]byte]
+	cryptoKey stream.CryptoKeyProvider
+	routes    stream.Routes[event.ReadEvent[[]byte]]
+	opts      stream.Options
+	ctx       context.Context
+}
+
+func NewRouter(s stream.Stream, cryptoKey stream.CryptoKeyProvider, ctx context.Context, opts ...stream.Option) (r *Router, err error) {
+	fs, err := stream.Init[[]byte](s, ctx, opts...)
+	if err != nil {
+		return
+	}
+	r = &Router{
+		stream:    fs,
+		cryptoKey: cryptoKey,
+		opts:      stream.NewOptions(opts...),
+		ctx:       ctx,
+	}
+	return
+}
+
+// Route registers h for events of dataType. Events that can not be decrypted into a T are reported as read errors.
+func Route[T any](r *Router, dataType string, h func(e event.ReadEvent[T])) {
+	RouteVersion(r, dataType, "", h)
+}
+
+// RouteVersion registers h for events of dataType stored with version, it takes precedence over the handler registered
+// with Route.
+func RouteVersion[T any](r *Router, dataType, version string, h func(e event.ReadEvent[T])) {
+	r.routes.Add(dataType, version, func(e event.ReadEvent[[]byte]) {
+		o, err := decryptEvent[T](e, r.cryptoKey, r.opts)
+		if err != nil {
+			r.opts.ReportReadError(stream.ReadError{Stream: r.stream.Name(), Event: StoredEvent(e), Stage: stream.StageData, Err: err}, r.ctx)
+			return
+		}
+		h(o)
+	})
+}
+
+// Fallback registers h for events of data types without a handler, with the data still encrypted.
+// Without a fallback only the registered data types are read.
+func (r *Router) Fallback(h func(e event.ReadEvent[[]byte])) {
+	r.routes.SetFallback(h)
+}
+
+// Stream starts routing the events of the given types from the position, filter may be nil. Handlers should be
+// registered before, events without a handler when they are read are skipped. Routing stops when ctx is done.
+func (r *Router) Stream(eventTypes []event.Type, from store.StreamPosition, filter stream.Filter, ctx context.Context) (err error) {
+	sel := r.routes.Selector()
+	sel.EventTypes = stream.SelectEventTypes(eventTypes...).EventTypes
+	mctx, cancel := mergedcontext.MergeContexts(r.ctx, ctx)
+	s, err := r.stream.StreamSelected(sel, from, filter, mctx)
+	if err != nil {
+		cancel()
+		return
+	}
+	go func() {
+		defer cancel()
+		for {
+			select {
+			case <-mctx.Done():
+				return
+			case e, ok := <-s:
+				if !ok {
+					return
+				}
+				r.routes.Dispatch(e.Metadata.DataType, e.Metadata.Version, e)
+			}
+		}
+	}()
+	return
+}
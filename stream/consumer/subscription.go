@@ -0,0 +1,128 @@
+package consumer
+
+import (
+	"context"
+	"sync"
+
+	log "github.com/cantara/bragi/sbragi"
+	"github.com/cantara/gober/mergedcontext"
+	"github.com/cantara/gober/stream"
+	"github.com/cantara/gober/stream/checkpoint"
+	"github.com/cantara/gober/stream/event"
+	"github.com/cantara/gober/stream/event/store"
+)
+
+// Subscribe streams the selected events from the position saved for name in checkpoints, so a named subscription
+// continues where it left off after a restart. The saved position advances over the delivered events as they are
+// acknowledged, but never past an event that is not acknowledged yet, so events acknowledged out of order are not lost
+// on a restart, while the events after an unacknowledged one may be delivered again.
+func (c *consumer[T]) Subscribe(name string, checkpoints checkpoint.Store, sel store.Selector, filter stream.Filter, ctx context.Context) (out <-chan event.ReadEventWAcc[T], err error) {
+	s, mctx, err := c.subscribe(name, checkpoints, sel, filter, ctx)
+	if err != nil {
+		return
+	}
+	out = c.retrying(s, mctx)
+	return
+}
+
+// subscribe streams the selected events from the checkpoint of name, with acknowledgements advancing the checkpoint.
+// The events are nacked by parking them, without retries.
+func (c *consumer[T]) subscribe(name string, checkpoints checkpoint.Store, sel store.Selector, filter stream.Filter, ctx context.Context) (out <-chan event.ReadEventWAcc[T], mctx context.Context, err error) {
+	from, err := checkpoints.Load(name)
+	if err != nil {
+		return
+	}
+	mctx, cancel := mergedcontext.MergeContexts(c.ctx, ctx)
+	s, err := c.streamReadEvents(sel, store.StreamPosition(from), filter, mctx)
+	if err != nil {
+		cancel()
+		return
+	}
+	out = relay(newSubscription(name, checkpoints, from), s, func(e event.ReadEventWAcc[T], ack func()) (event.ReadEventWAcc[T], uint64) {
+		acc := e.Acc
+		e.Acc = func() {
+			acc()
+			ack()
+		}
+		return e, e.Position
+	}, mctx, cancel)
+	return
+}
+
+// relay hands the events of in on, with their acknowledgement wrapped by wrap to also acknowledge them in the
+// subscription. wrap returns the wrapped event and the position it is delivered at.
+func relay[E any](sub *subscription, in <-chan E, wrap func(e E, ack func()) (E, uint64), ctx context.Context, cancel context.CancelFunc) <-chan E {
+	out := make(chan E, 0)
+	go func() {
+		defer cancel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e := <-in:
+				var position uint64
+				e, position = wrap(e, func() {
+					sub.ack(position)
+				})
+				sub.deliver(position)
+				select {
+				case <-ctx.Done():
+					return
+				case out <- e:
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// subscription tracks the delivered events of a named subscription and saves the position they are acknowledged up to.
+type subscription struct {
+	name        string
+	checkpoints checkpoint.Store
+	position    uint64
+	delivered   []uint64
+	acked       map[uint64]struct{}
+	lock        sync.Mutex
+}
+
+func newSubscription(name string, checkpoints checkpoint.Store, position uint64) *subscription {
+	return &subscription{
+		name:        name,
+		checkpoints: checkpoints,
+		position:    position,
+		acked:       make(map[uint64]struct{}),
+	}
+}
+
+func (s *subscription) deliver(position uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.delivered = append(s.delivered, position)
+}
+
+func (s *subscription) ack(position uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if position <= s.position {
+		return
+	}
+	s.acked[position] = struct{}{}
+	advanced := false
+	for len(s.delivered) > 0 {
+		if _, ok := s.acked[s.delivered[0]]; !ok {
+			break
+		}
+		delete(s.acked, s.delivered[0])
+		s.position = s.delivered[0]
+		s.delivered = s.delivered[1:]
+		advanced = true
+	}
+	if !advanced {
+		return
+	}
+	err := s.checkpoints.Save(s.name, s.position)
+	if err != nil {
+		log.WithError(err).Error("saving subscription checkpoint", "name", s.name, "position", s.position)
+	}
+}
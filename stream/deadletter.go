@@ -0,0 +1,136 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/cantara/bragi/sbragi"
+
+	"github.com/cantara/gober/stream/event"
+	"github.com/cantara/gober/stream/event/store"
+)
+
+// ReadStage is the step of reading an event that failed.
+type ReadStage string
+
+const (
+	StageMetadata  ReadStage = "metadata"
+	StageSignature ReadStage = "signature"
+	StageData      ReadStage = "data"
+	StageHandler   ReadStage = "handler"
+)
+
+// Keys in Metadata.Extra of dead-lettered events describing why and where from the event was dead-lettered.
+const (
+	DeadLetterStream   = "dead_letter_stream"
+	DeadLetterPosition = "dead_letter_position"
+	DeadLetterStage    = "dead_letter_stage"
+	DeadLetterError    = "dead_letter_error"
+	DeadLetterMetadata = "dead_letter_metadata"
+)
+
+// ReadError is an event that was skipped by a subscription because it could not be read, or, with StageHandler, because
+// its reader nacked it on its last attempt.
+// Event is the event as it is stored, so it can be inspected or written somewhere else unchanged.
+type ReadError struct {
+	Stream string
+	Event  store.ReadEvent
+	Stage  ReadStage
+	Err    error
+}
+
+func (e ReadError) Error() string {
+	return fmt.Sprintf("reading %s of event at %d in %s, error:%v", e.Stage, e.Event.Position, e.Stream, e.Err)
+}
+
+func (e ReadError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorHandler is called with every event a subscription skips. It is called from the subscription and delays
+// the events after it, so it should not block.
+type ErrorHandler func(err ReadError)
+
+// WithErrorHandler calls h with the events subscriptions skip because their metadata or data can not be read,
+// decrypted or verified, instead of only logging them.
+func WithErrorHandler(h ErrorHandler) Option {
+	return func(o *Options) {
+		o.ErrorHandler = h
+	}
+}
+
+// WithDeadLetter copies the events subscriptions skip to dl. The copy has the stored data and the original metadata
+// with the stream, position, stage and error added to Extra, see DeadLetterStream and friends. Metadata that can not be
+// read is kept as a string under DeadLetterMetadata. Every subscription copies the events it skips, so a stream with
+// several subscriptions can dead-letter the same event more than once.
+func WithDeadLetter(dl Stream) Option {
+	return func(o *Options) {
+		o.DeadLetter = dl
+	}
+}
+
+// ReportReadError hands a skipped event to the error handler and the dead-letter stream, if they are configured.
+func (o Options) ReportReadError(re ReadError, ctx context.Context) {
+	if re.Stage == StageHandler {
+		log.WithError(re.Err).Warning("Parking event that failed handling", "stream", re.Stream, "position", re.Event.Position)
+	} else {
+		log.WithError(re.Err).Warning("Skipping unreadable event", "stream", re.Stream, "position", re.Event.Position, "stage", re.Stage)
+	}
+	if o.ErrorHandler != nil {
+		o.ErrorHandler(re)
+	}
+	if o.DeadLetter == nil {
+		return
+	}
+	err := deadLetter(o.DeadLetter, re, ctx)
+	if err != nil {
+		log.WithError(err).Error("Writing event to dead-letter stream", "stream", re.Stream, "position", re.Event.Position, "dead_letter", o.DeadLetter.Name())
+	}
+}
+
+func deadLetter(dl Stream, re ReadError, ctx context.Context) (err error) {
+	var md event.Metadata
+	extra := map[string]any{
+		DeadLetterStream:   re.Stream,
+		DeadLetterPosition: re.Event.Position,
+		DeadLetterStage:    string(re.Stage),
+		DeadLetterError:    re.Err.Error(),
+	}
+	if json.Unmarshal(re.Event.Metadata, &md) != nil {
+		md = event.Metadata{
+			EventType: event.Type(re.Event.Type),
+			Created:   re.Event.Created,
+		}
+		extra[DeadLetterMetadata] = string(re.Event.Metadata)
+	}
+	for k, v := range md.Extra {
+		if _, ok := extra[k]; !ok {
+			extra[k] = v
+		}
+	}
+	md.Extra = extra
+	metadata, err := json.Marshal(md)
+	if err != nil {
+		return
+	}
+	status := make(chan store.WriteStatus, 1)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case dl.Write() <- store.WriteEvent{
+		Event: store.Event{
+			Id:       re.Event.Id,
+			Type:     re.Event.Type,
+			Data:     re.Event.Data,
+			Metadata: metadata,
+		},
+		Status: status,
+	}:
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case s := <-status:
+		return s.Error
+	}
+}
@@ -0,0 +1,203 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	log "github.com/cantara/bragi/sbragi"
+	"github.com/cantara/gober/stream/event"
+	"github.com/cantara/gober/stream/event/store"
+)
+
+var MissingFilterKeyError = errors.New("a filter key is required to cache the end of a filter")
+
+// maxCachedEnds bounds the selectors and filters the ends are kept up to date for per store, as every one of them
+// tails the store. Ends asked for beyond it are scanned for every call.
+const maxCachedEnds = 64
+
+// endCache keeps the filtered ends that have been asked for up to date by tailing the store.
+type endCache struct {
+	ends map[string]*cachedEnd
+	refs int
+	lock sync.Mutex
+}
+
+type cachedEnd struct {
+	pos     uint64
+	scanned uint64
+	err     error
+	cond    *sync.Cond
+	done    context.CancelFunc //Stops the tail of an end that is not cached once it is read
+}
+
+var endCaches = struct {
+	caches map[Stream]*endCache
+	lock   sync.Mutex
+}{
+	caches: make(map[Stream]*endCache),
+}
+
+// endCacheFor returns the end cache of st. It is shared by all the stream services of the store, so competing
+// consumers and other services reading the same store scan it once per selector and filter, not once each.
+// The cache is released once the contexts of all the services using it are done.
+func endCacheFor(st Stream, ctx context.Context) *endCache {
+	endCaches.lock.Lock()
+	defer endCaches.lock.Unlock()
+	c, ok := endCaches.caches[st]
+	if !ok {
+		c = &endCache{
+			ends: make(map[string]*cachedEnd),
+		}
+		endCaches.caches[st] = c
+	}
+	c.refs++
+	context.AfterFunc(ctx, func() {
+		endCaches.lock.Lock()
+		defer endCaches.lock.Unlock()
+		c.refs--
+		if c.refs == 0 && endCaches.caches[st] == c {
+			delete(endCaches.caches, st)
+		}
+	})
+	return c
+}
+
+// CachedFilteredEnd is FilteredEnd with the result cached per event types and filterKey, which has to identify the
+// filter and can not be empty when a filter is given. The first call scans the stream, after that the end is kept up to
+// date as events are written.
+func (es eventService[T]) CachedFilteredEnd(eventTypes []event.Type, filterKey string, filter Filter) (pos uint64, err error) {
+	if filter != nil && filterKey == "" {
+		err = MissingFilterKeyError
+		return
+	}
+	return es.cachedEnd(SelectEventTypes(eventTypes...), filterKey, filter)
+}
+
+func (es eventService[T]) cachedEnd(sel store.Selector, filterKey string, filter Filter) (pos uint64, err error) {
+	// Ends without a filter get their own key, so they are never mixed up with the end of a filter with the same key.
+	filterPart := "-"
+	if filter != nil {
+		filterPart = "f:" + filterKey
+	}
+	key := strings.Join(sel.EventTypes, ",") + "|" + strings.Join(sel.DataTypes, ",") + "|" + strings.Join(sel.Keys, ",") + "|" + filterPart
+	end, err := es.End()
+	if err != nil {
+		return
+	}
+	for {
+		var ce *cachedEnd
+		var cached bool
+		ce, cached, err = es.ends.entry(key, func(ctx context.Context) (*cachedEnd, error) {
+			return es.tailEnd(sel, filter, ctx)
+		}, es.ctx)
+		if err != nil {
+			return
+		}
+		pos, err = ce.wait(end)
+		if err == nil || !cached || es.ctx.Err() != nil {
+			return
+		}
+		// The tail was started by a service that has stopped since, start a new one.
+		log.WithError(err).Debug("Restarting tail of cached end", "stream", es.Name(), "key", key)
+	}
+}
+
+// entry returns the cached end of key, starting a tail of it if there is none or the tail has stopped. When the cache
+// is full the tail is only started for this caller, and stops with ctx.
+func (c *endCache) entry(key string, start func(ctx context.Context) (*cachedEnd, error), ctx context.Context) (ce *cachedEnd, cached bool, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if ce, ok := c.ends[key]; ok && !ce.stopped() {
+		return ce, true, nil
+	}
+	delete(c.ends, key)
+	if len(c.ends) >= maxCachedEnds {
+		log.Debug("End cache is full, scanning", "key", key)
+		sctx, cancel := context.WithCancel(ctx)
+		ce, err = start(sctx)
+		if err != nil {
+			cancel()
+			return
+		}
+		ce.done = cancel
+		return
+	}
+	ce, err = start(ctx)
+	if err != nil {
+		return
+	}
+	c.ends[key] = ce
+	return
+}
+
+func (ce *cachedEnd) stopped() bool {
+	ce.cond.L.Lock()
+	defer ce.cond.L.Unlock()
+	return ce.err != nil
+}
+
+// wait waits until the tail has read up to end and returns the last matching position, stopping the tail if the end
+// is not cached.
+func (ce *cachedEnd) wait(end uint64) (pos uint64, err error) {
+	ce.cond.L.Lock()
+	defer ce.cond.L.Unlock()
+	for ce.scanned < end && ce.err == nil {
+		ce.cond.Wait()
+	}
+	if ce.done != nil {
+		ce.done()
+	}
+	return ce.pos, ce.err
+}
+
+// tailEnd reads the whole stream and keeps reading new events until ctx is done, recording the last position matching
+// the selector and filter.
+func (es eventService[T]) tailEnd(sel store.Selector, filter Filter, ctx context.Context) (ce *cachedEnd, err error) {
+	s, err := es.store.Stream(store.STREAM_START, ctx)
+	if err != nil {
+		return
+	}
+	ce = &cachedEnd{
+		cond: sync.NewCond(&sync.Mutex{}),
+	}
+	go func() {
+		defer func() {
+			ce.cond.L.Lock()
+			ce.err = ctx.Err()
+			if ce.err == nil {
+				ce.err = context.Canceled
+			}
+			ce.cond.L.Unlock()
+			ce.cond.Broadcast()
+		}()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e, ok := <-s:
+				if !ok {
+					return
+				}
+				match := matchEventType(sel, e.Type)
+				if match {
+					var metadata event.Metadata
+					err := json.Unmarshal(e.Metadata, &metadata)
+					if err != nil {
+						log.WithError(err).Debug("Unmarshalling event metadata", "position", e.Position, "stream", es.Name())
+					}
+					match = err == nil && sel.Match(e.Type, metadata.DataType, metadata.Key) && (filter == nil || !filter(metadata))
+				}
+				ce.cond.L.Lock()
+				ce.scanned = e.Position
+				if match {
+					ce.pos = e.Position
+				}
+				ce.cond.L.Unlock()
+				ce.cond.Broadcast()
+			}
+		}
+	}()
+	return
+}
@@ -1,80 +1,187 @@
 package event
 
 import (
+	"context"
+	"errors"
+
 	log "github.com/cantara/bragi/sbragi"
 	"github.com/gofrs/uuid"
 )
 
-type builder struct {
-	Id       uuid.UUID
-	Type     Type
-	Data     []byte
-	Metadata Metadata
+var MissingDataTypeError = errors.New("event metadata is missing data type")
+var MissingVersionError = errors.New("event metadata is missing version")
+
+// Builder builds events of T. It is a value type so a partially configured builder can be reused as a template.
+type Builder[T any] struct {
+	id       uuid.UUID
+	t        Type
+	data     T
+	metadata Metadata
+	ctx      context.Context
+}
+
+// NewBuilder returns a builder for raw byte events.
+func NewBuilder() Builder[[]byte] {
+	return Builder[[]byte]{}
+}
+
+func NewBuilderFor[T any]() Builder[T] {
+	return Builder[T]{}
+}
+
+func (b Builder[T]) WithId(id uuid.UUID) Builder[T] {
+	b.id = id
+	return b
+}
+
+func (b Builder[T]) WithType(t Type) Builder[T] {
+	b.t = t
+	return b
+}
+
+func (b Builder[T]) WithData(data T) Builder[T] {
+	b.data = data
+	return b
+}
+
+// WithMetadata replaces all metadata, use it before the more specific With functions.
+func (b Builder[T]) WithMetadata(metadata Metadata) Builder[T] {
+	b.metadata = metadata
+	return b
+}
+
+func (b Builder[T]) WithKey(key string) Builder[T] {
+	b.metadata.Key = key
+	return b
+}
+
+func (b Builder[T]) WithDataType(dataType string) Builder[T] {
+	b.metadata.DataType = dataType
+	return b
+}
+
+func (b Builder[T]) WithVersion(version string) Builder[T] {
+	b.metadata.Version = version
+	return b
+}
+
+func (b Builder[T]) WithExtra(key string, value any) Builder[T] {
+	extra := make(map[string]any, len(b.metadata.Extra)+1)
+	for k, v := range b.metadata.Extra {
+		extra[k] = v
+	}
+	extra[key] = value
+	b.metadata.Extra = extra
+	return b
 }
 
-type Builder interface {
-	WithType(t Type) builder
-	WithData(data []byte) builder
-	WithMetadata(data Metadata) builder
-	BuildRead() (ev ByteReadEvent, err error)
-	BuildStore() (ev WriteEvent[[]byte], err error)
+func (b Builder[T]) WithCorrelationId(id string) Builder[T] {
+	b.metadata.CorrelationId = id
+	return b
 }
 
-func NewBuilder() Builder {
-	return builder{}
+func (b Builder[T]) WithCausationId(id string) Builder[T] {
+	b.metadata.CausationId = id
+	return b
 }
 
-func (e builder) WithType(t Type) builder {
-	e.Type = t
-	return e
+func (b Builder[T]) WithTraceParent(traceParent string) Builder[T] {
+	b.metadata.TraceParent = traceParent
+	return b
 }
 
-func (e builder) WithData(data []byte) builder {
-	e.Data = data
-	return e
+// WithContext sets the context the correlation fields not set explicitly are taken from when the event is written.
+func (b Builder[T]) WithContext(ctx context.Context) Builder[T] {
+	b.ctx = ctx
+	return b
 }
 
-func (e builder) WithMetadata(data Metadata) builder {
-	e.Metadata = data
-	return e
+// Validate checks that the event has a valid type and the metadata required by consumers to interpret it.
+func (b Builder[T]) Validate() (err error) {
+	if !b.t.Valid() {
+		return InvalidTypeError
+	}
+	if b.metadata.DataType == "" {
+		return MissingDataTypeError
+	}
+	if b.metadata.Version == "" {
+		return MissingVersionError
+	}
+	if b.metadata.TraceParent != "" && !ValidTraceParent(b.metadata.TraceParent) {
+		return InvalidTraceParentError
+	}
+	return
+}
+
+// Build validates the event and returns it ready to be written to any FilteredStream or Consumer.
+// An id is generated if none is set.
+func (b Builder[T]) Build() (we WriteEventReadStatus[T], err error) {
+	err = b.Validate()
+	if err != nil {
+		log.WithError(err).Error("while building event")
+		return
+	}
+	e, err := b.event()
+	if err != nil {
+		return
+	}
+	if b.ctx == nil {
+		we = NewWriteEvent(e)
+		return
+	}
+	we = NewWriteEventWithContext(b.ctx, e)
+	return
 }
 
-func (e builder) BuildRead() (ev ByteReadEvent, err error) {
-	if e.Type == "" {
-		log.Error("missing event type in builder")
+func (b Builder[T]) BuildRead() (ev ReadEvent[T], err error) {
+	if !b.t.Valid() {
+		log.Error("missing or invalid event type in builder", "type", b.t)
 		err = InvalidTypeError
 		return
 	}
-	e.Metadata.EventType = e.Type
-	ev = ByteReadEvent{
-		Event: Event[[]byte]{
-			Type:     e.Type,
-			Data:     e.Data,
-			Metadata: e.Metadata,
+	b.metadata.EventType = b.t
+	ev = ReadEvent[T]{
+		Event: Event[T]{
+			Id:       b.id,
+			Type:     b.t,
+			Data:     b.data,
+			Metadata: b.metadata,
 		},
 	}
 	return
 }
 
-func (e builder) BuildStore() (ev WriteEvent[[]byte], err error) {
-	if e.Type == "" {
-		log.Error("missing event type in builder")
+// BuildStore only requires a valid type, use Build to also validate the metadata.
+func (b Builder[T]) BuildStore() (ev WriteEvent[T], err error) {
+	if !b.t.Valid() {
+		log.Error("missing or invalid event type in builder", "type", b.t)
 		err = InvalidTypeError
 		return
 	}
-	if e.Id.IsNil() {
-		e.Id, err = uuid.NewV7()
+	e, err := b.event()
+	if err != nil {
+		return
+	}
+	ev = WriteEvent[T]{
+		event: e,
+		ctx:   b.ctx,
+	}
+	return
+}
+
+func (b Builder[T]) event() (e Event[T], err error) {
+	if b.id.IsNil() {
+		b.id, err = uuid.NewV7()
 		if err != nil {
 			return
 		}
 	}
-	e.Metadata.EventType = e.Type
-	ev = WriteEvent[[]byte]{
-		event: Event[[]byte]{
-			Type:     e.Type,
-			Data:     e.Data,
-			Metadata: e.Metadata,
-		},
+	b.metadata.EventType = b.t
+	e = Event[T]{
+		Id:       b.id,
+		Type:     b.t,
+		Data:     b.data,
+		Metadata: b.metadata,
 	}
 	return
 }
@@ -0,0 +1,119 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/gofrs/uuid"
+)
+
+type dd struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestBuilderValidation(t *testing.T) {
+	base := NewBuilderFor[dd]().
+		WithType(Created).
+		WithDataType("dd").
+		WithVersion("v1")
+	for _, test := range []struct {
+		builder Builder[dd]
+		err     error
+	}{
+		{base.WithType(""), InvalidTypeError},
+		{base.WithType("not_registered"), InvalidTypeError},
+		{base.WithDataType(""), MissingDataTypeError},
+		{base.WithVersion(""), MissingVersionError},
+		{base.WithTraceParent("not-a-traceparent"), InvalidTraceParentError},
+	} {
+		_, err := test.builder.Build()
+		if !errors.Is(err, test.err) {
+			t.Errorf("expected %v, got %v", test.err, err)
+			return
+		}
+	}
+}
+
+func TestBuilderBuild(t *testing.T) {
+	id := uuid.Must(uuid.NewV7())
+	template := NewBuilderFor[dd]().
+		WithId(id).
+		WithType(Updated).
+		WithData(dd{
+			Id:   1,
+			Name: "test",
+		}).
+		WithKey("key").
+		WithDataType("dd").
+		WithVersion("v1").
+		WithExtra("a", 1).
+		WithCorrelationId("correlation").
+		WithCausationId("causation")
+	we, err := template.WithExtra("b", 2).
+		WithContext(context.Background()).
+		Build()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	e := we.Event()
+	if e.Id != id || e.Type != Updated || e.Metadata.EventType != Updated {
+		t.Errorf("missmatch id or type, %v", e)
+		return
+	}
+	if e.Data.Id != 1 || e.Data.Name != "test" {
+		t.Errorf("missmatch data, %v", e.Data)
+		return
+	}
+	md := e.Metadata
+	if md.Key != "key" || md.DataType != "dd" || md.Version != "v1" || md.CorrelationId != "correlation" || md.CausationId != "causation" {
+		t.Errorf("missmatch metadata, %v", md)
+		return
+	}
+	if len(md.Extra) != 2 {
+		t.Errorf("missmatch metadata extra, %v", md.Extra)
+		return
+	}
+	if len(template.metadata.Extra) != 1 {
+		t.Error("building from a template should not modify the template")
+		return
+	}
+	se := we.Store()
+	if se.Id != id {
+		t.Errorf("missmatch stored id, %s != %s", se.Id, id)
+		return
+	}
+	we, err = template.WithId(uuid.Nil).Build()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if we.Event().Id.IsNil() {
+		t.Error("expected an id to be generated")
+	}
+}
+
+func TestMetadataOmitsEmptyFields(t *testing.T) {
+	b, err := json.Marshal(Metadata{
+		Stream:   "s",
+		DataType: "dd",
+		Key:      "k",
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var fields map[string]any
+	err = json.Unmarshal(b, &fields)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, field := range []string{"codec", "compression", "claim_check", "correlation_id", "causation_id", "traceparent", "signer_key_id", "signature"} {
+		if _, ok := fields[field]; ok {
+			t.Errorf("missmatch, empty field %s is marshalled", field)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package claimcheck
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// BlobStore stores payloads that are too large to be kept in the event itself. The event carries only the reference.
+type BlobStore interface {
+	Put(data []byte) (ref string, err error)
+	Get(ref string) (data []byte, err error)
+}
+
+// DefaultThreshold is the payload size in bytes above which payloads are offloaded.
+const DefaultThreshold = 256 << 10
+
+var InvalidReferenceError = errors.New("claim check reference is invalid")
+var CorruptBlobError = errors.New("claim check blob does not match its reference")
+
+var refRegex = regexp.MustCompile(`^sha256-[0-9a-f]{64}$`)
+
+// Local is a content-addressed BlobStore on the local filesystem, a blob is stored in a file named by its sha256.
+type Local struct {
+	dir string
+}
+
+func NewLocal(dir string) (l *Local, err error) {
+	err = os.MkdirAll(dir, 0750)
+	if err != nil {
+		return
+	}
+	l = &Local{
+		dir: dir,
+	}
+	return
+}
+
+func Reference(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256-" + hex.EncodeToString(sum[:])
+}
+
+func (l *Local) path(ref string) string {
+	return filepath.Join(l.dir, ref[7:9], ref)
+}
+
+func (l *Local) Put(data []byte) (ref string, err error) {
+	ref = Reference(data)
+	p := l.path(ref)
+	if _, err = os.Stat(p); err == nil {
+		return
+	}
+	err = os.MkdirAll(filepath.Dir(p), 0750)
+	if err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), ref+".tmp*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return
+	}
+	err = tmp.Sync()
+	if err != nil {
+		tmp.Close()
+		return
+	}
+	err = tmp.Close()
+	if err != nil {
+		return
+	}
+	err = os.Rename(tmp.Name(), p)
+	return
+}
+
+func (l *Local) Get(ref string) (data []byte, err error) {
+	if !refRegex.MatchString(ref) {
+		err = fmt.Errorf("reference %q, error:%w", ref, InvalidReferenceError)
+		return
+	}
+	data, err = os.ReadFile(l.path(ref))
+	if err != nil {
+		return
+	}
+	if Reference(data) != ref {
+		err = fmt.Errorf("reference %s, error:%w", ref, CorruptBlobError)
+		data = nil
+	}
+	return
+}
@@ -0,0 +1,71 @@
+package claimcheck
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestPutGet(t *testing.T) {
+	l, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	data := bytes.Repeat([]byte("payload"), 1024)
+	ref, err := l.Put(data)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if ref != Reference(data) {
+		t.Errorf("reference is not content addressed, %s != %s", ref, Reference(data))
+		return
+	}
+	again, err := l.Put(data)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if again != ref {
+		t.Errorf("missmatch reference for same data, %s != %s", again, ref)
+		return
+	}
+	out, err := l.Get(ref)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !bytes.Equal(out, data) {
+		t.Error("missmatch blob data")
+		return
+	}
+}
+
+func TestGetInvalid(t *testing.T) {
+	l, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = l.Get("../../etc/passwd")
+	if !errors.Is(err, InvalidReferenceError) {
+		t.Errorf("expected invalid reference error, got %v", err)
+		return
+	}
+	ref, err := l.Put([]byte("payload"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = os.WriteFile(l.path(ref), []byte("tampered"), 0640)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = l.Get(ref)
+	if !errors.Is(err, CorruptBlobError) {
+		t.Errorf("expected corrupt blob error, got %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Codec serializes event data. The name is recorded in the event metadata so that readers can pick the same codec.
+type Codec interface {
+	Name() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+const (
+	JSONName     = "json"
+	CBORName     = "cbor"
+	MsgPackName  = "msgpack"
+	ProtobufName = "protobuf"
+)
+
+var (
+	JSON     Codec = jsonCodec{}
+	CBOR     Codec = cborCodec{}
+	MsgPack  Codec = msgpackCodec{}
+	Protobuf Codec = protobufCodec{}
+)
+
+var UnknownCodecError = errors.New("codec is not registered")
+
+var (
+	codecs = map[string]Codec{
+		JSONName:     JSON,
+		CBORName:     CBOR,
+		MsgPackName:  MsgPack,
+		ProtobufName: Protobuf,
+	}
+	dataTypeCodecs = make(map[string]Codec)
+	lock           sync.RWMutex
+)
+
+// Register makes c available for decoding events that have its name recorded in their metadata.
+func Register(c Codec) {
+	lock.Lock()
+	defer lock.Unlock()
+	codecs[c.Name()] = c
+}
+
+// SetForDataType selects c for all events of dataType, overriding the codec selected for the stream.
+func SetForDataType(dataType string, c Codec) {
+	lock.Lock()
+	defer lock.Unlock()
+	codecs[c.Name()] = c
+	dataTypeCodecs[dataType] = c
+}
+
+// ForName returns the codec registered under name. Events written before codecs were recorded have no name and are JSON.
+func ForName(name string) (c Codec, err error) {
+	if name == "" {
+		return JSON, nil
+	}
+	lock.RLock()
+	defer lock.RUnlock()
+	c, ok := codecs[name]
+	if !ok {
+		err = fmt.Errorf("codec %s, error:%w", name, UnknownCodecError)
+	}
+	return
+}
+
+// Resolve selects the codec for a new event. An explicitly named codec wins, then the one set for the data type,
+// then the fallback, usually selected per stream, and last JSON.
+func Resolve(name, dataType string, fallback Codec) (c Codec, err error) {
+	if name != "" {
+		return ForName(name)
+	}
+	lock.RLock()
+	c, ok := dataTypeCodecs[dataType]
+	lock.RUnlock()
+	if ok {
+		return
+	}
+	if fallback != nil {
+		return fallback, nil
+	}
+	return JSON, nil
+}
@@ -0,0 +1,83 @@
+package codec
+
+import (
+	"errors"
+	"testing"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type dd struct {
+	Id   int    `json:"id" cbor:"id" msgpack:"id"`
+	Name string `json:"name" cbor:"name" msgpack:"name"`
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, c := range []Codec{JSON, CBOR, MsgPack} {
+		in := dd{
+			Id:   1,
+			Name: "test",
+		}
+		b, err := c.Marshal(in)
+		if err != nil {
+			t.Error(c.Name(), err)
+			return
+		}
+		var out dd
+		err = c.Unmarshal(b, &out)
+		if err != nil {
+			t.Error(c.Name(), err)
+			return
+		}
+		if in != out {
+			t.Errorf("%s: missmatch data, %v != %v", c.Name(), in, out)
+			return
+		}
+	}
+}
+
+func TestProtobuf(t *testing.T) {
+	in := wrapperspb.String("test")
+	b, err := Protobuf.Marshal(in)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var out *wrapperspb.StringValue
+	err = Protobuf.Unmarshal(b, &out)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if out.GetValue() != in.GetValue() {
+		t.Errorf("missmatch data, %s != %s", out.GetValue(), in.GetValue())
+		return
+	}
+	_, err = Protobuf.Marshal(dd{})
+	if !errors.Is(err, NotProtoMessageError) {
+		t.Errorf("expected not proto message error, got %v", err)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	c, err := Resolve("", "", nil)
+	if err != nil || c != JSON {
+		t.Errorf("expected json as default codec, got %v %v", c, err)
+		return
+	}
+	SetForDataType("codec_test", CBOR)
+	c, err = Resolve("", "codec_test", MsgPack)
+	if err != nil || c != CBOR {
+		t.Errorf("expected data type codec to override stream codec, got %v %v", c, err)
+		return
+	}
+	c, err = Resolve(MsgPackName, "codec_test", nil)
+	if err != nil || c != MsgPack {
+		t.Errorf("expected named codec to override data type codec, got %v %v", c, err)
+		return
+	}
+	_, err = ForName("unknown")
+	if !errors.Is(err, UnknownCodecError) {
+		t.Errorf("expected unknown codec error, got %v", err)
+	}
+}
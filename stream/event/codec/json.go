@@ -0,0 +1,19 @@
+package codec
+
+import jsoniter "github.com/json-iterator/go"
+
+var json = jsoniter.ConfigDefault
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return JSONName
+}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,17 @@
+package codec
+
+import "github.com/vmihailenco/msgpack/v5"
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string {
+	return MsgPackName
+}
+
+func (msgpackCodec) Marshal(v any) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v any) error {
+	return msgpack.Unmarshal(data, v)
+}
@@ -0,0 +1,50 @@
+package codec
+
+import (
+	"errors"
+	"reflect"
+
+	"google.golang.org/protobuf/proto"
+)
+
+var NotProtoMessageError = errors.New("protobuf codec requires data to be a proto.Message")
+
+type protobufCodec struct{}
+
+func (protobufCodec) Name() string {
+	return ProtobufName
+}
+
+func (protobufCodec) Marshal(v any) ([]byte, error) {
+	m, ok := protoMessage(v)
+	if !ok {
+		return nil, NotProtoMessageError
+	}
+	return proto.Marshal(m)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v any) error {
+	m, ok := protoMessage(v)
+	if !ok {
+		return NotProtoMessageError
+	}
+	return proto.Unmarshal(data, m)
+}
+
+// protoMessage handles both v being a message and v being a pointer to a message pointer,
+// which is what a generic T of *pb.Message gives when unmarshalling into &data.
+func protoMessage(v any) (m proto.Message, ok bool) {
+	m, ok = v.(proto.Message)
+	if ok {
+		return
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Pointer {
+		return
+	}
+	if rv.Elem().IsNil() {
+		rv.Elem().Set(reflect.New(rv.Elem().Type().Elem()))
+	}
+	m, ok = rv.Elem().Interface().(proto.Message)
+	return
+}
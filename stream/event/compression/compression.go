@@ -0,0 +1,122 @@
+package compression
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Compressor compresses event data. The name is recorded in the event metadata so readers can decompress.
+type Compressor interface {
+	Name() string
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+const (
+	ZstdName   = "zstd"
+	SnappyName = "snappy"
+	GzipName   = "gzip"
+)
+
+// DefaultThreshold is the size in bytes below which data is left uncompressed, as the overhead outweighs the gain.
+const DefaultThreshold = 512
+
+var (
+	Zstd   Compressor = zstdCompressor{}
+	Snappy Compressor = snappyCompressor{}
+	Gzip   Compressor = gzipCompressor{}
+)
+
+// DefaultMaxSize is the size in bytes data is decompressed to at most, unless another limit is given.
+const DefaultMaxSize = 64 << 20
+
+var UnknownCompressorError = errors.New("compressor is not registered")
+var DataTooLargeError = errors.New("decompressed data is larger than the limit")
+
+// LimitedDecompressor is implemented by compressors that can stop decompressing once the data is larger than a limit,
+// so data that expands to much more than it is stored as does not exhaust memory. All the built in compressors do.
+type LimitedDecompressor interface {
+	DecompressLimit(data []byte, limit int) ([]byte, error)
+}
+
+var (
+	compressors = map[string]Compressor{
+		ZstdName:   Zstd,
+		SnappyName: Snappy,
+		GzipName:   Gzip,
+	}
+	lock sync.RWMutex
+)
+
+func Register(c Compressor) {
+	lock.Lock()
+	defer lock.Unlock()
+	compressors[c.Name()] = c
+}
+
+// ForName returns the compressor registered under name, or nil if name is empty and the data is uncompressed.
+func ForName(name string) (c Compressor, err error) {
+	if name == "" {
+		return
+	}
+	lock.RLock()
+	defer lock.RUnlock()
+	c, ok := compressors[name]
+	if !ok {
+		err = fmt.Errorf("compressor %s, error:%w", name, UnknownCompressorError)
+	}
+	return
+}
+
+// Compress compresses data with c if it is at least threshold bytes. The returned name is empty when data was left raw.
+func Compress(c Compressor, threshold int, data []byte) (out []byte, name string, err error) {
+	if c == nil || len(data) < threshold {
+		return data, "", nil
+	}
+	out, err = c.Compress(data)
+	if err != nil {
+		return
+	}
+	name = c.Name()
+	return
+}
+
+// Decompress reverses Compress given the name recorded in the metadata, limited to DefaultMaxSize bytes.
+func Decompress(name string, data []byte) (out []byte, err error) {
+	return DecompressLimit(name, data, DefaultMaxSize)
+}
+
+// DecompressLimit reverses Compress given the name recorded in the metadata, failing with DataTooLargeError if the data
+// decompresses to more than limit bytes. Compressors that are not a LimitedDecompressor are only checked after
+// decompressing.
+func DecompressLimit(name string, data []byte, limit int) (out []byte, err error) {
+	c, err := ForName(name)
+	if err != nil {
+		return
+	}
+	if c == nil {
+		return data, nil
+	}
+	if l, ok := c.(LimitedDecompressor); ok {
+		return l.DecompressLimit(data, limit)
+	}
+	out, err = c.Decompress(data)
+	if err == nil && len(out) > limit {
+		return nil, fmt.Errorf("size %d over %d, error:%w", len(out), limit, DataTooLargeError)
+	}
+	return
+}
+
+// readLimit reads r to the end, failing if it has more than limit bytes.
+func readLimit(r io.Reader, limit int) (out []byte, err error) {
+	out, err = io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return
+	}
+	if len(out) > limit {
+		return nil, fmt.Errorf("limit %d, error:%w", limit, DataTooLargeError)
+	}
+	return
+}
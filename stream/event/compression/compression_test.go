@@ -0,0 +1,103 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte(`{"id":1,"name":"test"}`), 100)
+	for _, c := range []Compressor{Zstd, Snappy, Gzip} {
+		compressed, name, err := Compress(c, DefaultThreshold, data)
+		if err != nil {
+			t.Error(c.Name(), err)
+			return
+		}
+		if name != c.Name() {
+			t.Errorf("missmatch compressor name, %s != %s", name, c.Name())
+			return
+		}
+		if len(compressed) >= len(data) {
+			t.Errorf("%s: compressed data is not smaller, %d >= %d", c.Name(), len(compressed), len(data))
+			return
+		}
+		out, err := Decompress(name, compressed)
+		if err != nil {
+			t.Error(c.Name(), err)
+			return
+		}
+		if !bytes.Equal(out, data) {
+			t.Errorf("%s: missmatch data after decompression", c.Name())
+			return
+		}
+	}
+}
+
+func TestThreshold(t *testing.T) {
+	data := []byte(`{"id":1}`)
+	out, name, err := Compress(Zstd, DefaultThreshold, data)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if name != "" || !bytes.Equal(out, data) {
+		t.Error("data below threshold should be left raw")
+		return
+	}
+	out, err = Decompress("", data)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !bytes.Equal(out, data) {
+		t.Error("raw data should be returned as is")
+	}
+}
+
+func TestDecompressLimit(t *testing.T) {
+	data := bytes.Repeat([]byte{'a'}, 1<<20)
+	for _, c := range []Compressor{Zstd, Snappy, Gzip} {
+		compressed, name, err := Compress(c, DefaultThreshold, data)
+		if err != nil {
+			t.Error(c.Name(), err)
+			return
+		}
+		_, err = DecompressLimit(name, compressed, len(data)-1)
+		if !errors.Is(err, DataTooLargeError) {
+			t.Errorf("%s: missmatch error decompressing over the limit, %v", c.Name(), err)
+			return
+		}
+		out, err := DecompressLimit(name, compressed, len(data))
+		if err != nil {
+			t.Error(c.Name(), err)
+			return
+		}
+		if !bytes.Equal(out, data) {
+			t.Errorf("%s: missmatch data decompressed at the limit", c.Name())
+			return
+		}
+	}
+}
+
+func TestDecompressOverDefaultMaxSize(t *testing.T) {
+	data := bytes.Repeat([]byte{'a'}, DefaultMaxSize+1)
+	compressed, name, err := Compress(Zstd, DefaultThreshold, data)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = Decompress(name, compressed)
+	if !errors.Is(err, DataTooLargeError) {
+		t.Errorf("missmatch error decompressing over the default max size, %v", err)
+		return
+	}
+	out, err := DecompressLimit(name, compressed, 2*DefaultMaxSize)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(out) != len(data) {
+		t.Errorf("missmatch decompressed size with a raised limit, %d != %d", len(out), len(data))
+	}
+}
@@ -0,0 +1,40 @@
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+)
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Name() string {
+	return GzipName
+}
+
+func (gzipCompressor) Compress(data []byte) (out []byte, err error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err = w.Write(data)
+	if err != nil {
+		return
+	}
+	err = w.Close()
+	if err != nil {
+		return
+	}
+	out = buf.Bytes()
+	return
+}
+
+func (g gzipCompressor) Decompress(data []byte) (out []byte, err error) {
+	return g.DecompressLimit(data, DefaultMaxSize)
+}
+
+func (gzipCompressor) DecompressLimit(data []byte, limit int) (out []byte, err error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+	defer r.Close()
+	return readLimit(r, limit)
+}
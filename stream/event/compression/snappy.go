@@ -0,0 +1,33 @@
+package compression
+
+import (
+	"fmt"
+
+	"github.com/klauspost/compress/snappy"
+)
+
+type snappyCompressor struct{}
+
+func (snappyCompressor) Name() string {
+	return SnappyName
+}
+
+func (snappyCompressor) Compress(data []byte) ([]byte, error) {
+	return snappy.Encode(nil, data), nil
+}
+
+func (s snappyCompressor) Decompress(data []byte) ([]byte, error) {
+	return s.DecompressLimit(data, DefaultMaxSize)
+}
+
+// DecompressLimit checks the decoded length recorded in the data before decoding it.
+func (snappyCompressor) DecompressLimit(data []byte, limit int) ([]byte, error) {
+	n, err := snappy.DecodedLen(data)
+	if err != nil {
+		return nil, err
+	}
+	if n > limit {
+		return nil, fmt.Errorf("size %d over %d, error:%w", n, limit, DataTooLargeError)
+	}
+	return snappy.Decode(nil, data)
+}
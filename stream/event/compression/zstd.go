@@ -0,0 +1,63 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// EncodeAll is safe for concurrent use, so one encoder is shared. DecodeAll can not be limited, so data is decompressed
+// with pooled stream decoders that decode synchronously. The decoders cap their memory at the limit they decompress to,
+// so there is a pool per limit.
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoders   sync.Map // map[int]*sync.Pool
+)
+
+func zstdDecoderPool(limit int) *sync.Pool {
+	if p, ok := zstdDecoders.Load(limit); ok {
+		return p.(*sync.Pool)
+	}
+	p, _ := zstdDecoders.LoadOrStore(limit, &sync.Pool{
+		New: func() any {
+			d, _ := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(uint64(limit)))
+			return d
+		},
+	})
+	return p.(*sync.Pool)
+}
+
+type zstdCompressor struct{}
+
+func (zstdCompressor) Name() string {
+	return ZstdName
+}
+
+func (zstdCompressor) Compress(data []byte) ([]byte, error) {
+	return zstdEncoder.EncodeAll(data, nil), nil
+}
+
+func (z zstdCompressor) Decompress(data []byte) ([]byte, error) {
+	return z.DecompressLimit(data, DefaultMaxSize)
+}
+
+func (zstdCompressor) DecompressLimit(data []byte, limit int) (out []byte, err error) {
+	pool := zstdDecoderPool(limit)
+	d := pool.Get().(*zstd.Decoder)
+	defer func() {
+		// Reset so the pooled decoder does not keep the data alive.
+		d.Reset(nil)
+		pool.Put(d)
+	}()
+	err = d.Reset(bytes.NewReader(data))
+	if err == nil {
+		out, err = readLimit(d, limit)
+	}
+	if errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded) {
+		err = fmt.Errorf("limit %d, error:%w", limit, DataTooLargeError)
+	}
+	return
+}
@@ -5,26 +5,37 @@ import (
 	"time"
 
 	log "github.com/cantara/bragi/sbragi"
+	"github.com/cantara/gober/stream/event/codec"
 	"github.com/cantara/gober/stream/event/store"
+	"github.com/gofrs/uuid"
 	jsoniter "github.com/json-iterator/go"
 )
 
 var json = jsoniter.ConfigDefault
 
 type Metadata struct {
-	Stream    string         `json:"stream"`
-	EventType Type           `json:"event_type"`
-	Version   string         `json:"version"`
-	DataType  string         `json:"data_type"`
-	Key       string         `json:"key"` //Strictly used for things like getting the cryptoKey
-	Extra     map[string]any `json:"extra"`
-	Created   time.Time      `json:"created"`
+	Stream        string         `json:"stream"`
+	EventType     Type           `json:"event_type"`
+	Version       string         `json:"version"`
+	DataType      string         `json:"data_type"`
+	Key           string         `json:"key"` //Strictly used for things like getting the cryptoKey
+	Codec         string         `json:"codec,omitempty"`
+	Compression   string         `json:"compression,omitempty"`
+	ClaimCheck    string         `json:"claim_check,omitempty"`
+	CorrelationId string         `json:"correlation_id,omitempty"`
+	CausationId   string         `json:"causation_id,omitempty"`
+	TraceParent   string         `json:"traceparent,omitempty"`
+	SignerKeyId   string         `json:"signer_key_id,omitempty"`
+	Signature     []byte         `json:"signature,omitempty"`
+	Extra         map[string]any `json:"extra"`
+	Created       time.Time      `json:"created"`
 }
 
 type Event[T any] struct {
-	Type     Type     `json:"type"`
-	Data     T        `json:"data"`
-	Metadata Metadata `json:"metadata"`
+	Id       uuid.UUID `json:"id"`
+	Type     Type      `json:"type"`
+	Data     T         `json:"data"`
+	Metadata Metadata  `json:"metadata"`
 }
 
 type ReadEvent[T any] struct {
@@ -32,22 +43,35 @@ type ReadEvent[T any] struct {
 
 	Position uint64    `json:"position"`
 	Created  time.Time `json:"created"`
+	Shredded bool      `json:"shredded"` //Tombstone for an event whose encryption key is shredded, only the metadata is available
+	Verified bool      `json:"verified"` //Signature checked against the trust store of the stream
 }
 
 type ReadEventWAcc[T any] struct {
 	ReadEvent[T]
 
-	Acc func()
-	CTX context.Context
+	Acc  func()
+	Nack func(err error) //Reports that handling the event failed, it is redelivered or parked by the retry policy of the stream
+	CTX  context.Context
+}
+
+// ReadBatchWAcc is a batch of read events in stream order, acknowledged together with a single Acc.
+type ReadBatchWAcc[T any] struct {
+	Events []ReadEvent[T]
+
+	Acc  func()
+	Nack func(err error) //Reports that handling the batch failed, it is redelivered or has all its events parked by the retry policy of the stream
 }
 
 type WriteEvent[T any] struct {
 	event  Event[T]
 	status chan store.WriteStatus
+	ctx    context.Context
 }
 
 type WriteEventReadStatus[T any] interface {
 	Event() *Event[T]
+	Context() context.Context
 	Done() <-chan store.WriteStatus
 	Close(store.WriteStatus)
 	Store() *store.WriteEvent
@@ -57,11 +81,13 @@ type WriteEventReadStatus[T any] interface {
 func Map[OT, NT any](e WriteEventReadStatus[OT], f func(OT) NT) WriteEventReadStatus[NT] {
 	return &WriteEvent[NT]{
 		event: Event[NT]{
+			Id:       e.Event().Id,
 			Type:     e.Event().Type,
 			Data:     f(e.Event().Data),
 			Metadata: e.Event().Metadata,
 		},
 		status: e.StatusChan(),
+		ctx:    e.Context(),
 	}
 }
 
@@ -72,10 +98,27 @@ func NewWriteEvent[T any](e Event[T]) WriteEventReadStatus[T] { //Dont think i l
 	}
 }
 
+// NewWriteEventWithContext is NewWriteEvent where the correlation, causation and traceparent of the event is taken from ctx,
+// typically the CTX of the read event that is being handled.
+func NewWriteEventWithContext[T any](ctx context.Context, e Event[T]) WriteEventReadStatus[T] {
+	return &WriteEvent[T]{
+		event:  e,
+		status: make(chan store.WriteStatus, 1),
+		ctx:    ctx,
+	}
+}
+
 func (e *WriteEvent[T]) Event() *Event[T] {
 	return &e.event
 }
 
+func (e *WriteEvent[T]) Context() context.Context {
+	if e.ctx == nil {
+		return context.Background()
+	}
+	return e.ctx
+}
+
 func (e *WriteEvent[T]) Done() <-chan store.WriteStatus {
 	return e.status
 }
@@ -101,7 +144,7 @@ func (e *WriteEvent[T]) Store() *store.WriteEvent {
 		})
 		return nil
 	}
-	dByte, err := json.Marshal(e.event.Data)
+	dByte, err := marshalData(e.event.Metadata.Codec, e.event.Data)
 	if err != nil {
 		log.WithError(err).Error("while marshaling data")
 		e.Close(store.WriteStatus{
@@ -111,6 +154,7 @@ func (e *WriteEvent[T]) Store() *store.WriteEvent {
 	}
 	return &store.WriteEvent{
 		Event: store.Event{
+			Id:       e.event.Id,
 			Type:     string(e.event.Type),
 			Data:     dByte,
 			Metadata: mByte,
@@ -119,6 +163,19 @@ func (e *WriteEvent[T]) Store() *store.WriteEvent {
 	}
 }
 
+// marshalData encodes data with the named codec. Raw []byte data, like encrypted payloads, is the codec output of a
+// layer above and keeps the JSON envelope it has always had.
+func marshalData[T any](codecName string, data T) ([]byte, error) {
+	if _, raw := any(data).([]byte); raw {
+		return json.Marshal(data)
+	}
+	c, err := codec.ForName(codecName)
+	if err != nil {
+		return nil, err
+	}
+	return c.Marshal(data)
+}
+
 type ByteEvent Event[[]byte]
 type ByteWriteEvent WriteEvent[[]byte]
 type ByteReadEvent ReadEvent[[]byte]
@@ -0,0 +1,135 @@
+package signature
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/cantara/gober/stream/event"
+	"github.com/gofrs/uuid"
+	jsoniter "github.com/json-iterator/go"
+)
+
+// json sorts map keys so the signed metadata encoding does not depend on map iteration order.
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+// Policy decides what happens to events that do not carry a valid signature from a trusted signer.
+type Policy int
+
+const (
+	// Reject drops every event that is not signed by a trusted signer.
+	Reject Policy = iota
+	// Flag delivers every event, only those with a valid signature have Verified set.
+	Flag
+	// AcceptUnsigned delivers unsigned events, but drops events with an invalid or untrusted signature.
+	AcceptUnsigned
+)
+
+var UnsignedEventError = errors.New("event is not signed")
+var InvalidSignatureError = errors.New("event signature is invalid")
+var InvalidKeyIdError = errors.New("signer key id can not be empty")
+
+type Signer struct {
+	keyId string
+	key   ed25519.PrivateKey
+}
+
+func NewSigner(keyId string, key ed25519.PrivateKey) (s *Signer, err error) {
+	if keyId == "" {
+		err = InvalidKeyIdError
+		return
+	}
+	if len(key) != ed25519.PrivateKeySize {
+		err = fmt.Errorf("ed25519 private key is %d bytes, expected %d", len(key), ed25519.PrivateKeySize)
+		return
+	}
+	s = &Signer{
+		keyId: keyId,
+		key:   key,
+	}
+	return
+}
+
+// GenerateSigner creates a signer with a new key pair, the public key is to be added to the trust stores of readers.
+func GenerateSigner(keyId string) (s *Signer, pub ed25519.PublicKey, err error) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return
+	}
+	s, err = NewSigner(keyId, key)
+	return
+}
+
+func (s *Signer) KeyId() string {
+	return s.keyId
+}
+
+// Sign sets the signer key id and signature of md. The signature covers the id, type, the stored data and the metadata.
+func (s *Signer) Sign(id uuid.UUID, t event.Type, data []byte, md *event.Metadata) (err error) {
+	md.SignerKeyId = s.keyId
+	payload, err := signingPayload(id, t, data, *md)
+	if err != nil {
+		return
+	}
+	md.Signature = ed25519.Sign(s.key, payload)
+	return
+}
+
+// Verify checks the signature of md with the public key the trust store has for the signer key id.
+func Verify(trust TrustStore, id uuid.UUID, t event.Type, data []byte, md event.Metadata) (err error) {
+	if md.SignerKeyId == "" || len(md.Signature) == 0 {
+		return UnsignedEventError
+	}
+	pub, err := trust.PublicKey(md.SignerKeyId)
+	if err != nil {
+		return
+	}
+	payload, err := signingPayload(id, t, data, md)
+	if err != nil {
+		return
+	}
+	if !ed25519.Verify(pub, payload, md.Signature) {
+		return fmt.Errorf("signer %s, error:%w", md.SignerKeyId, InvalidSignatureError)
+	}
+	return
+}
+
+// Accept applies the policy to the result of Verify, returning if the event is to be delivered.
+func (p Policy) Accept(verifyErr error) bool {
+	switch p {
+	case Flag:
+		return true
+	case AcceptUnsigned:
+		return verifyErr == nil || errors.Is(verifyErr, UnsignedEventError)
+	}
+	return verifyErr == nil
+}
+
+// signingPayload is id||len(type)||type||len(data)||data||metadata, where metadata is without the signature and
+// normalized through a json round trip so that the writer and readers encode it the same way.
+func signingPayload(id uuid.UUID, t event.Type, data []byte, md event.Metadata) (payload []byte, err error) {
+	md.Signature = nil
+	mdb, err := json.Marshal(md)
+	if err != nil {
+		return
+	}
+	var normalized event.Metadata
+	err = json.Unmarshal(mdb, &normalized)
+	if err != nil {
+		return
+	}
+	mdb, err = json.Marshal(normalized)
+	if err != nil {
+		return
+	}
+	payload = make([]byte, 0, len(id)+len(t)+len(data)+len(mdb)+2*binary.MaxVarintLen64)
+	payload = append(payload, id.Bytes()...)
+	payload = binary.AppendUvarint(payload, uint64(len(t)))
+	payload = append(payload, t...)
+	payload = binary.AppendUvarint(payload, uint64(len(data)))
+	payload = append(payload, data...)
+	payload = append(payload, mdb...)
+	return
+}
@@ -0,0 +1,117 @@
+package signature
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cantara/gober/stream/event"
+	"github.com/gofrs/uuid"
+)
+
+func TestSignVerify(t *testing.T) {
+	s, pub, err := GenerateSigner("producer")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	trust := NewLocal()
+	err = trust.Add(s.KeyId(), pub)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	id := uuid.Must(uuid.NewV7())
+	md := event.Metadata{
+		DataType: "user",
+		Key:      "key",
+		Extra: map[string]any{
+			"b": 2,
+			"a": "one",
+		},
+		Created: time.Now(),
+	}
+	err = s.Sign(id, event.Created, []byte("data"), &md)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if md.SignerKeyId != "producer" {
+		t.Errorf("signer key id missmatch %s", md.SignerKeyId)
+		return
+	}
+	err = Verify(trust, id, event.Created, []byte("data"), md)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = Verify(trust, id, event.Created, []byte("tampered"), md)
+	if !errors.Is(err, InvalidSignatureError) {
+		t.Errorf("expected invalid signature error, got %v", err)
+		return
+	}
+	tampered := md
+	tampered.Key = "other"
+	err = Verify(trust, id, event.Created, []byte("data"), tampered)
+	if !errors.Is(err, InvalidSignatureError) {
+		t.Errorf("expected invalid signature error for tampered metadata, got %v", err)
+		return
+	}
+	trust.Remove(s.KeyId())
+	err = Verify(trust, id, event.Created, []byte("data"), md)
+	if !errors.Is(err, UnknownSignerError) {
+		t.Errorf("expected unknown signer error, got %v", err)
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	invalid := InvalidSignatureError
+	for _, test := range []struct {
+		policy   Policy
+		err      error
+		expected bool
+	}{
+		{Reject, nil, true},
+		{Reject, UnsignedEventError, false},
+		{Reject, invalid, false},
+		{Flag, UnsignedEventError, true},
+		{Flag, invalid, true},
+		{AcceptUnsigned, UnsignedEventError, true},
+		{AcceptUnsigned, invalid, false},
+		{AcceptUnsigned, UnknownSignerError, false},
+	} {
+		if test.policy.Accept(test.err) != test.expected {
+			t.Errorf("policy %d accept missmatch for %v", test.policy, test.err)
+		}
+	}
+}
+
+func TestLoadLocal(t *testing.T) {
+	s, pub, err := GenerateSigner("producer")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	dir := t.TempDir()
+	err = os.WriteFile(filepath.Join(dir, s.KeyId()+PublicKeyExtension), []byte(base64.StdEncoding.EncodeToString(pub)+"\n"), 0640)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	trust, err := LoadLocal(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	loaded, err := trust.PublicKey(s.KeyId())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !loaded.Equal(pub) {
+		t.Error("loaded public key missmatch")
+	}
+}
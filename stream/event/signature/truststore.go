@@ -0,0 +1,95 @@
+package signature
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// TrustStore resolves signer key ids to the public keys events signed by them are verified with.
+type TrustStore interface {
+	PublicKey(keyId string) (ed25519.PublicKey, error)
+}
+
+var UnknownSignerError = errors.New("signer key id is not in the trust store")
+
+// PublicKeyExtension is the extension of the public key files LoadLocal reads.
+const PublicKeyExtension = ".pub"
+
+type Local struct {
+	keys map[string]ed25519.PublicKey
+	lock sync.RWMutex
+}
+
+func NewLocal() *Local {
+	return &Local{
+		keys: make(map[string]ed25519.PublicKey),
+	}
+}
+
+// LoadLocal creates a trust store from a directory with one file per signer, named by key id with PublicKeyExtension
+// and containing the base64 encoded public key.
+func LoadLocal(dir string) (ts *Local, err error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	local := NewLocal()
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), PublicKeyExtension) {
+			continue
+		}
+		var b []byte
+		b, err = os.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return
+		}
+		var pub []byte
+		pub, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+		if err != nil {
+			err = fmt.Errorf("public key file %s, error:%w", f.Name(), err)
+			return
+		}
+		err = local.Add(strings.TrimSuffix(f.Name(), PublicKeyExtension), pub)
+		if err != nil {
+			return
+		}
+	}
+	ts = local
+	return
+}
+
+func (ts *Local) Add(keyId string, pub ed25519.PublicKey) (err error) {
+	if keyId == "" {
+		return InvalidKeyIdError
+	}
+	if len(pub) != ed25519.PublicKeySize {
+		return fmt.Errorf("ed25519 public key for %s is %d bytes, expected %d", keyId, len(pub), ed25519.PublicKeySize)
+	}
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	ts.keys[keyId] = pub
+	return
+}
+
+// Remove revokes trust in the signer, events signed by it no longer verify.
+func (ts *Local) Remove(keyId string) {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	delete(ts.keys, keyId)
+}
+
+func (ts *Local) PublicKey(keyId string) (pub ed25519.PublicKey, err error) {
+	ts.lock.RLock()
+	defer ts.lock.RUnlock()
+	pub, ok := ts.keys[keyId]
+	if !ok {
+		err = fmt.Errorf("signer %s, error:%w", keyId, UnknownSignerError)
+	}
+	return
+}
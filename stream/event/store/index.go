@@ -0,0 +1,198 @@
+package store
+
+import (
+	"slices"
+	"sort"
+	"sync"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+var json = jsoniter.ConfigFastest
+
+// Selector describes the events to read by the fields stores index. Events match when they match every non-empty field,
+// and a field matches when the event has any of its values.
+type Selector struct {
+	EventTypes []string `json:"event_types,omitempty"`
+	DataTypes  []string `json:"data_types,omitempty"`
+	Keys       []string `json:"keys,omitempty"`
+}
+
+func (s Selector) Empty() bool {
+	return len(s.EventTypes) == 0 && len(s.DataTypes) == 0 && len(s.Keys) == 0
+}
+
+func (s Selector) Match(eventType, dataType, key string) bool {
+	return matchField(s.EventTypes, eventType) && matchField(s.DataTypes, dataType) && matchField(s.Keys, key)
+}
+
+func matchField(values []string, v string) bool {
+	return len(values) == 0 || slices.Contains(values, v)
+}
+
+// IndexFields are the metadata fields that are indexed.
+type IndexFields struct {
+	DataType string `json:"data_type"`
+	Key      string `json:"key"`
+}
+
+// ParseIndexFields reads only the indexed fields from the stored metadata.
+func ParseIndexFields(metadata []byte) (f IndexFields, err error) {
+	err = json.Unmarshal(metadata, &f)
+	return
+}
+
+type indexEntry struct {
+	eventType string
+	IndexFields
+}
+
+// Index maps event types, data types and keys to the positions of the events that have them.
+// Positions are expected to start at 1 and be added in order without gaps.
+type Index struct {
+	entries    []indexEntry
+	eventTypes map[string][]uint64
+	dataTypes  map[string][]uint64
+	keys       map[string][]uint64
+	lock       sync.RWMutex
+}
+
+func NewIndex() *Index {
+	return &Index{
+		eventTypes: make(map[string][]uint64),
+		dataTypes:  make(map[string][]uint64),
+		keys:       make(map[string][]uint64),
+	}
+}
+
+// Add indexes the event at position. Events with unreadable metadata are indexed with empty data type and key.
+func (i *Index) Add(position uint64, eventType string, metadata []byte) {
+	f, _ := ParseIndexFields(metadata)
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	for uint64(len(i.entries)) < position-1 {
+		i.entries = append(i.entries, indexEntry{})
+	}
+	i.entries = append(i.entries, indexEntry{
+		eventType:   eventType,
+		IndexFields: f,
+	})
+	i.eventTypes[eventType] = append(i.eventTypes[eventType], position)
+	i.dataTypes[f.DataType] = append(i.dataTypes[f.DataType], position)
+	i.keys[f.Key] = append(i.keys[f.Key], position)
+}
+
+// Remove removes the event at position, if it is the last one added, so a store can undo an event it failed to write.
+func (i *Index) Remove(position uint64) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	if position == 0 || position != uint64(len(i.entries)) {
+		return
+	}
+	e := i.entries[position-1]
+	i.entries = i.entries[:position-1]
+	i.eventTypes[e.eventType] = removeLast(i.eventTypes[e.eventType], position)
+	i.dataTypes[e.DataType] = removeLast(i.dataTypes[e.DataType], position)
+	i.keys[e.Key] = removeLast(i.keys[e.Key], position)
+}
+
+func removeLast(l []uint64, position uint64) []uint64 {
+	if len(l) > 0 && l[len(l)-1] == position {
+		return l[:len(l)-1]
+	}
+	return l
+}
+
+// Match reports if the event at position is selected.
+func (i *Index) Match(position uint64, sel Selector) bool {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+	return i.match(position, sel)
+}
+
+func (i *Index) match(position uint64, sel Selector) bool {
+	if position == 0 || position > uint64(len(i.entries)) {
+		return false
+	}
+	e := i.entries[position-1]
+	return sel.Match(e.eventType, e.DataType, e.Key)
+}
+
+// Next returns the first selected position after the given position, ok is false if there is none yet.
+func (i *Index) Next(sel Selector, after uint64) (position uint64, ok bool) {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+	if sel.Empty() {
+		if after < uint64(len(i.entries)) {
+			return after + 1, true
+		}
+		return
+	}
+	lists := i.narrowest(sel)
+	for {
+		position, ok = 0, false
+		for _, l := range lists {
+			n := sort.Search(len(l), func(j int) bool { return l[j] > after })
+			if n < len(l) && (!ok || l[n] < position) {
+				position, ok = l[n], true
+			}
+		}
+		if !ok || i.match(position, sel) {
+			return
+		}
+		after = position
+	}
+}
+
+// Last returns the last selected position, or 0 if no event is selected.
+func (i *Index) Last(sel Selector) (position uint64) {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+	if sel.Empty() {
+		return uint64(len(i.entries))
+	}
+	lists := i.narrowest(sel)
+	before := uint64(len(i.entries)) + 1
+	for {
+		position = 0
+		for _, l := range lists {
+			n := sort.Search(len(l), func(j int) bool { return l[j] >= before })
+			if n > 0 && l[n-1] > position {
+				position = l[n-1]
+			}
+		}
+		if position == 0 || i.match(position, sel) {
+			return
+		}
+		before = position
+	}
+}
+
+// narrowest returns the position lists of the selector field with the fewest positions.
+func (i *Index) narrowest(sel Selector) (lists [][]uint64) {
+	best := -1
+	for _, field := range []struct {
+		values []string
+		index  map[string][]uint64
+	}{
+		{sel.EventTypes, i.eventTypes},
+		{sel.DataTypes, i.dataTypes},
+		{sel.Keys, i.keys},
+	} {
+		if len(field.values) == 0 {
+			continue
+		}
+		var fieldLists [][]uint64
+		size := 0
+		for _, v := range field.values {
+			l := field.index[v]
+			size += len(l)
+			fieldLists = append(fieldLists, l)
+		}
+		if best < 0 || size < best {
+			best = size
+			lists = fieldLists
+		}
+	}
+	return
+}
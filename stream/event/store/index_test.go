@@ -0,0 +1,54 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+	index := NewIndex()
+	for i := uint64(1); i <= 10; i++ {
+		dataType := "even"
+		if i%2 == 1 {
+			dataType = "odd"
+		}
+		index.Add(i, "created", []byte(fmt.Sprintf(`{"data_type":"%s","key":"k%d"}`, dataType, i%3)))
+	}
+	var positions []uint64
+	sel := Selector{DataTypes: []string{"odd"}, Keys: []string{"k0"}}
+	for p, ok := index.Next(sel, 0); ok; p, ok = index.Next(sel, p) {
+		positions = append(positions, p)
+	}
+	if fmt.Sprint(positions) != "[3 9]" {
+		t.Errorf("selected positions missmatch %v", positions)
+		return
+	}
+	if last := index.Last(sel); last != 9 {
+		t.Errorf("last selected position missmatch %d != 9", last)
+		return
+	}
+	if last := index.Last(Selector{DataTypes: []string{"even"}}); last != 10 {
+		t.Errorf("last selected position missmatch %d != 10", last)
+		return
+	}
+	if last := index.Last(Selector{DataTypes: []string{"missing"}}); last != 0 {
+		t.Errorf("last selected position missmatch %d != 0", last)
+		return
+	}
+	if _, ok := index.Next(Selector{}, 10); ok {
+		t.Error("selected position after end")
+	}
+	index.Remove(10)
+	if last := index.Last(Selector{DataTypes: []string{"even"}}); last != 8 {
+		t.Errorf("last selected position after remove missmatch %d != 8", last)
+		return
+	}
+	if last := index.Last(Selector{}); last != 9 {
+		t.Errorf("last position after remove missmatch %d != 9", last)
+		return
+	}
+	index.Remove(5)
+	if last := index.Last(Selector{}); last != 9 {
+		t.Errorf("last position after removing a middle position missmatch %d != 9", last)
+	}
+}
@@ -24,6 +24,7 @@ type stream struct {
 
 type Stream struct {
 	data      stream
+	index     *store.Index
 	name      string
 	writeChan chan<- store.WriteEvent
 	ctx       context.Context
@@ -37,6 +38,7 @@ func Init(name string, ctx context.Context) (es *Stream, err error) {
 			dbLock:  &sync.Mutex{},
 			newData: sync.NewCond(&sync.Mutex{}),
 		},
+		index:     store.NewIndex(),
 		name:      name,
 		writeChan: writeChan,
 		ctx:       ctx,
@@ -62,6 +64,7 @@ func Init(name string, ctx context.Context) (es *Stream, err error) {
 					}
 
 					es.data.db = append(es.data.db, se)
+					es.index.Add(se.Position, se.Event.Type, se.Event.Metadata)
 					es.data.position = se.Position
 					if e.Status != nil {
 						e.Status <- store.WriteStatus{
@@ -70,7 +73,9 @@ func Init(name string, ctx context.Context) (es *Stream, err error) {
 						}
 					}
 
+					es.data.newData.L.Lock()
 					es.data.newData.Broadcast()
+					es.data.newData.L.Unlock()
 				}()
 			}
 		}
@@ -126,6 +131,58 @@ func (es *Stream) Stream(from store.StreamPosition, ctx context.Context) (out <-
 	return
 }
 
+// StreamSelected streams only the events matching the selector, found through the index.
+func (es *Stream) StreamSelected(sel store.Selector, from store.StreamPosition, ctx context.Context) (out <-chan store.ReadEvent, err error) {
+	eventChan := make(chan store.ReadEvent, 2)
+	out = eventChan
+	go func() {
+		defer close(eventChan)
+		position := uint64(from)
+		if from == store.STREAM_END {
+			position = es.index.Last(store.Selector{})
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-es.ctx.Done():
+				return
+			default:
+			}
+			es.data.newData.L.Lock()
+			next, ok := es.index.Next(sel, position)
+			if !ok {
+				es.data.newData.Wait()
+				es.data.newData.L.Unlock()
+				continue
+			}
+			es.data.newData.L.Unlock()
+			es.data.dbLock.Lock()
+			se := es.data.db[next-1]
+			es.data.dbLock.Unlock()
+			select {
+			case <-ctx.Done():
+				return
+			case <-es.ctx.Done():
+				return
+			case eventChan <- store.ReadEvent{
+				Event:    se.Event,
+				Position: se.Position,
+				Created:  se.Created,
+			}:
+			}
+			position = next
+		}
+	}()
+	return
+}
+
+// SelectedEnd returns the position of the last event matching the selector.
+func (es *Stream) SelectedEnd(sel store.Selector) (pos uint64, err error) {
+	pos = es.index.Last(sel)
+	return
+}
+
 func (es *Stream) Name() string {
 	return es.name
 }
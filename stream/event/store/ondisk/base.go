@@ -31,6 +31,20 @@ type storeEvent struct {
 	Created  time.Time
 }
 
+// storedHeader is a stored event with the event left undecoded, so events that are skipped are read without decoding
+// their data.
+type storedHeader struct {
+	Event    jsoniter.RawMessage
+	Position uint64
+	Created  time.Time
+}
+
+// indexedEvent is the part of a stored event that is indexed.
+type indexedEvent struct {
+	Type     string `json:"type"`
+	Metadata []byte `json:"metadata"`
+}
+
 // stream Need to add a way to not store multiple events with the same id in the same stream.
 type stream struct {
 	db  *os.File
@@ -42,6 +56,7 @@ type stream struct {
 
 type Stream struct {
 	data      stream
+	index     *store.Index
 	name      string
 	writeChan chan<- store.WriteEvent
 	ctx       context.Context
@@ -56,15 +71,22 @@ func Init(name string, ctx context.Context) (s *Stream, err error) {
 	}
 	defer f.Close()
 	j := json.NewDecoder(f)
-	var se storeEvent
+	var sh storedHeader
 	p := uint64(0)
+	index := store.NewIndex()
 	for j.More() {
-		err = j.Decode(&se)
+		err = j.Decode(&sh)
 		if err != nil {
 			return
 		}
-		if p < se.Position {
-			p = se.Position
+		var ie indexedEvent
+		err = json.Unmarshal(sh.Event, &ie)
+		if err != nil {
+			return
+		}
+		index.Add(sh.Position, ie.Type, ie.Metadata)
+		if p < sh.Position {
+			p = sh.Position
 		}
 	}
 	f, err = os.OpenFile(fmt.Sprintf("streams/%s", name), os.O_WRONLY|os.O_APPEND|os.O_SYNC, 0640)
@@ -79,6 +101,7 @@ func Init(name string, ctx context.Context) (s *Stream, err error) {
 			newData:  sync.NewCond(&sync.Mutex{}),
 			position: p,
 		},
+		index:     index,
 		name:      name,
 		writeChan: writeChan,
 		ctx:       ctx,
@@ -97,7 +120,6 @@ func writeStrem(s *Stream, writes <-chan store.WriteEvent) {
 		log.WithError(fmt.Errorf("%v", r)).Error("recovering write stream", "stream", s.name)
 		writeStrem(s, writes)
 	}()
-	stream := json.NewEncoder(s.data.db)
 	for {
 		select {
 		case <-s.ctx.Done():
@@ -114,9 +136,18 @@ func writeStrem(s *Stream, writes <-chan store.WriteEvent) {
 					Position: uint64(s.data.len.Add(1)),
 					Created:  time.Now(),
 				}
-				err := stream.Encode(se)
+				// Indexed before it is written so readers never see an event the index does not know, and removed again if
+				// the write fails. Readers only read up to the written position, so they never see the removed entry.
+				s.index.Add(se.Position, se.Event.Type, se.Event.Metadata)
+				// Marshalled and written in one write, an encoder flushes large events in parts that readers can see.
+				b, err := json.Marshal(se)
+				if err == nil {
+					_, err = s.data.db.Write(append(b, '\n'))
+				}
 				if err != nil {
 					log.WithError(err).Error("while writing event to file")
+					s.index.Remove(se.Position)
+					s.data.len.Add(-1)
 					if e.Status != nil {
 						e.Status <- store.WriteStatus{
 							Error: err,
@@ -146,11 +177,26 @@ func (s *Stream) Write() chan<- store.WriteEvent {
 func (s *Stream) Stream(from store.StreamPosition, ctx context.Context) (out <-chan store.ReadEvent, err error) {
 	eventChan := make(chan store.ReadEvent, 2)
 	out = eventChan
-	go readStream(s, eventChan, uint64(from), ctx)
+	go readStream(s, eventChan, uint64(from), store.Selector{}, ctx)
+	return
+}
+
+// StreamSelected streams only the events matching the selector. The file is still read in order, but events that are
+// not selected by the index are skipped without being sent.
+func (s *Stream) StreamSelected(sel store.Selector, from store.StreamPosition, ctx context.Context) (out <-chan store.ReadEvent, err error) {
+	eventChan := make(chan store.ReadEvent, 2)
+	out = eventChan
+	go readStream(s, eventChan, uint64(from), sel, ctx)
 	return
 }
 
-func readStream(s *Stream, events chan<- store.ReadEvent, position uint64, ctx context.Context) {
+// SelectedEnd returns the position of the last event matching the selector.
+func (s *Stream) SelectedEnd(sel store.Selector) (pos uint64, err error) {
+	pos = s.index.Last(sel)
+	return
+}
+
+func readStream(s *Stream, events chan<- store.ReadEvent, position uint64, sel store.Selector, ctx context.Context) {
 	exit := false
 	defer func() {
 		if exit {
@@ -163,7 +209,7 @@ func readStream(s *Stream, events chan<- store.ReadEvent, position uint64, ctx c
 			return
 		}
 		log.WithError(fmt.Errorf("%v", r)).Error("recovering read stream", "stream", s.name)
-		readStream(s, events, position, ctx)
+		readStream(s, events, position, sel, ctx)
 	}()
 	db, err := os.OpenFile(fmt.Sprintf("streams/%s", s.name), os.O_RDONLY, 0640)
 	//db, err := os.OpenFile(es.data.db.Name(), os.O_RDONLY, 0640)
@@ -187,9 +233,9 @@ func readStream(s *Stream, events chan<- store.ReadEvent, position uint64, ctx c
 		position = uint64(s.data.len.Load())
 	}
 	readTo := uint64(0)
-	var se storeEvent
+	var sh storedHeader
 	for readTo < position {
-		err := stream.Decode(&se)
+		err := stream.Decode(&sh)
 		if err != nil {
 			if errors.Is(err, io.EOF) {
 				time.Sleep(time.Millisecond * 250)
@@ -197,7 +243,7 @@ func readStream(s *Stream, events chan<- store.ReadEvent, position uint64, ctx c
 			}
 			log.WithError(err).Fatal("while unmarshalling event from store catchup", "name", s.name)
 		}
-		readTo = se.Position
+		readTo = sh.Position
 	}
 	for !exit {
 		log.Trace("starting new reader loop", "name", s.name)
@@ -211,7 +257,7 @@ func readStream(s *Stream, events chan<- store.ReadEvent, position uint64, ctx c
 		default:
 			for stream.More() {
 				log.Trace("has more", "name", s.name)
-				err := stream.Decode(&se)
+				err := stream.Decode(&sh)
 				if err != nil {
 					/*Should not happen
 					if errors.Is(err, io.EOF) {
@@ -221,12 +267,20 @@ func readStream(s *Stream, events chan<- store.ReadEvent, position uint64, ctx c
 					*/
 					log.WithError(err).Fatal("while unmarshalling event from store", "name", s.name)
 				}
+				position = sh.Position
+				if !sel.Empty() && !s.index.Match(sh.Position, sel) {
+					continue
+				}
+				var e store.Event
+				err = json.Unmarshal(sh.Event, &e)
+				if err != nil {
+					log.WithError(err).Fatal("while unmarshalling event from store", "name", s.name)
+				}
 				events <- store.ReadEvent{
-					Event:    se.Event,
-					Position: se.Position,
-					Created:  se.Created,
+					Event:    e,
+					Position: sh.Position,
+					Created:  sh.Created,
 				}
-				position = se.Position
 			}
 			log.Trace("empty checking if there has come new data", "name", s.name, "p", position, "dl", s.data.len.Load(), "dp", s.data.position)
 			if position >= uint64(s.data.len.Load()) {
@@ -203,6 +203,53 @@ func TestStoreAndStream(t *testing.T) {
 	wg.Wait()
 }
 
+func TestStreamSelected(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, dataType := range []string{"selected", "other", "selected"} {
+		status := make(chan store.WriteStatus, 1)
+		es.Write() <- store.WriteEvent{
+			Event: store.Event{
+				Id:       uuid.Must(uuid.NewV7()),
+				Type:     string(event.Updated),
+				Data:     []byte("{}"),
+				Metadata: []byte(fmt.Sprintf(`{"data_type":"%s"}`, dataType)),
+			},
+			Status: status,
+		}
+		s := <-status
+		if s.Error != nil {
+			t.Error(s.Error)
+			return
+		}
+	}
+	end, err := es.End()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	sel := store.Selector{DataTypes: []string{"selected"}}
+	selectedEnd, err := es.SelectedEnd(sel)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if selectedEnd != end {
+		t.Error(fmt.Errorf("missmatch selected end, %d != %d", selectedEnd, end))
+		return
+	}
+	s, err := es.StreamSelected(sel, store.STREAM_START, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	first := <-s
+	second := <-s
+	if first.Position != end-2 || second.Position != end {
+		t.Error(fmt.Errorf("missmatch selected positions, %d and %d", first.Position, second.Position))
+	}
+}
+
 func TestTeardown(t *testing.T) {
 	cancel()
 }
@@ -0,0 +1,79 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"regexp"
+
+	"github.com/gofrs/uuid"
+)
+
+// Trace is what follows a request through the events it causes.
+// CorrelationId is shared by all events in a chain, CausationId is the id of the event that caused this one and
+// TraceParent is a W3C traceparent header value.
+type Trace struct {
+	CorrelationId string
+	CausationId   string
+	TraceParent   string
+}
+
+type traceKey struct{}
+
+var InvalidTraceParentError = errors.New("traceparent is not a valid W3C traceparent")
+
+var traceParentRegex = regexp.MustCompile(`^[0-9a-f]{2}-[0-9a-f]{32}-[0-9a-f]{16}-[0-9a-f]{2}$`)
+
+func ValidTraceParent(tp string) bool {
+	return traceParentRegex.MatchString(tp) && tp[3:35] != "00000000000000000000000000000000" && tp[36:52] != "0000000000000000"
+}
+
+func ContextWithTrace(ctx context.Context, t Trace) context.Context {
+	return context.WithValue(ctx, traceKey{}, t)
+}
+
+func TraceFromContext(ctx context.Context) (t Trace, ok bool) {
+	if ctx == nil {
+		return
+	}
+	t, ok = ctx.Value(traceKey{}).(Trace)
+	return
+}
+
+// ContextWithTraceParent adds an incoming W3C traceparent, e.g. from an HTTP request, to the trace in ctx.
+func ContextWithTraceParent(ctx context.Context, traceParent string) context.Context {
+	t, _ := TraceFromContext(ctx)
+	t.TraceParent = traceParent
+	return ContextWithTrace(ctx, t)
+}
+
+// ContextWithEvent returns a context for handling the event with the given id and metadata.
+// Events written with it are correlated with, and caused by, that event.
+func ContextWithEvent(ctx context.Context, id uuid.UUID, md Metadata) context.Context {
+	t := Trace{
+		CorrelationId: md.CorrelationId,
+		CausationId:   id.String(),
+		TraceParent:   md.TraceParent,
+	}
+	if t.CorrelationId == "" {
+		t.CorrelationId = t.CausationId
+	}
+	return ContextWithTrace(ctx, t)
+}
+
+// ApplyTrace fills the trace fields of the metadata that are not already set from ctx.
+// Events without a trace in ctx start a new chain and are correlated with themselves.
+func (md *Metadata) ApplyTrace(ctx context.Context, id uuid.UUID) {
+	t, _ := TraceFromContext(ctx)
+	if md.CorrelationId == "" {
+		md.CorrelationId = t.CorrelationId
+		if md.CorrelationId == "" {
+			md.CorrelationId = id.String()
+		}
+	}
+	if md.CausationId == "" {
+		md.CausationId = t.CausationId
+	}
+	if md.TraceParent == "" && ValidTraceParent(t.TraceParent) {
+		md.TraceParent = t.TraceParent
+	}
+}
@@ -2,6 +2,8 @@ package event
 
 import (
 	"fmt"
+	"regexp"
+	"sync"
 )
 
 type Type string
@@ -13,10 +15,71 @@ const (
 	Invalid Type = "invalid"
 )
 
+// AllTypes returns the built-in types. These carry the create/update/delete semantics used by eventmap and friends,
+// use RegisteredTypes to also include the custom domain types.
 func AllTypes() []Type {
 	return []Type{Created, Updated, Deleted}
 }
 
+var (
+	customTypes     = make(map[Type]struct{})
+	customTypesLock sync.RWMutex
+	typeNameRegex   = regexp.MustCompile(`^[a-z][a-z0-9_.-]{0,63}$`)
+)
+
+// RegisterType adds a custom domain event type, like "shipped" or "approved", so that it is accepted when writing and
+// recognised when reading. Registering the same name again, or the name of a built-in type, returns the existing type.
+func RegisterType(name string) (t Type, err error) {
+	if !typeNameRegex.MatchString(name) {
+		err = fmt.Errorf("event type name %q, error:%w", name, InvalidTypeNameError)
+		return
+	}
+	t = Type(name)
+	if t == Invalid {
+		err = fmt.Errorf("event type name %q, error:%w", name, ReservedTypeNameError)
+		return
+	}
+	if t.IsBuiltin() {
+		return
+	}
+	customTypesLock.Lock()
+	defer customTypesLock.Unlock()
+	customTypes[t] = struct{}{}
+	return
+}
+
+// MustRegisterType is RegisterType that panics on error, intended for package level variables.
+func MustRegisterType(name string) Type {
+	t, err := RegisterType(name)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// RegisteredTypes returns the built-in types followed by all registered custom types.
+func RegisteredTypes() (types []Type) {
+	types = AllTypes()
+	customTypesLock.RLock()
+	defer customTypesLock.RUnlock()
+	for t := range customTypes {
+		types = append(types, t)
+	}
+	return
+}
+
+func (t Type) IsBuiltin() bool {
+	switch t {
+	case Created, Updated, Deleted:
+		return true
+	}
+	return false
+}
+
+func (t Type) Valid() bool {
+	return TypeFromString(string(t)) != Invalid
+}
+
 func TypeFromString(s string) Type {
 	switch s {
 	case string(Created):
@@ -26,7 +89,14 @@ func TypeFromString(s string) Type {
 	case string(Deleted):
 		return Deleted
 	}
+	customTypesLock.RLock()
+	defer customTypesLock.RUnlock()
+	if _, ok := customTypes[Type(s)]; ok {
+		return Type(s)
+	}
 	return Invalid
 }
 
 var InvalidTypeError = fmt.Errorf("event type is invalid")
+var InvalidTypeNameError = fmt.Errorf("event type name must be lowercase alphanumeric, '_', '.' or '-' and start with a letter")
+var ReservedTypeNameError = fmt.Errorf("event type name is reserved")
@@ -0,0 +1,110 @@
+package event
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// UpcastFunc transforms the raw data, encoded with the codec the event was written with, of one version of a data type
+// into the shape of the next version.
+type UpcastFunc func(data []byte) ([]byte, error)
+
+type upcastStep struct {
+	to     string
+	upcast UpcastFunc
+}
+
+type dataTypeVersions struct {
+	current string
+	strict  bool
+	steps   map[string]upcastStep
+}
+
+var (
+	upcasters     = make(map[string]*dataTypeVersions)
+	upcastersLock sync.RWMutex
+)
+
+var UnknownVersionError = errors.New("no upcaster path from event version to current version")
+var UpcastLoopError = errors.New("upcasters form a loop")
+
+func getOrInitVersions(dataType string) *dataTypeVersions {
+	v, ok := upcasters[dataType]
+	if !ok {
+		v = &dataTypeVersions{
+			steps: make(map[string]upcastStep),
+		}
+		upcasters[dataType] = v
+	}
+	return v
+}
+
+// SetCurrentVersion sets the version events of dataType are upcast to before they are unmarshalled.
+// In strict mode events with a version that has no upcaster path to the current version are rejected,
+// otherwise they are passed through as is.
+func SetCurrentVersion(dataType, version string, strict bool) {
+	upcastersLock.Lock()
+	defer upcastersLock.Unlock()
+	v := getOrInitVersions(dataType)
+	v.current = version
+	v.strict = strict
+}
+
+// RegisterUpcaster registers f as the transformation of dataType data from fromVersion to toVersion.
+// Upcasters are chained, so registering v1->v2 and v2->v3 upcasts v1 events to v3.
+func RegisterUpcaster(dataType, fromVersion, toVersion string, f UpcastFunc) (err error) {
+	if fromVersion == toVersion {
+		err = fmt.Errorf("upcaster for %s from %s to %s, error:%w", dataType, fromVersion, toVersion, UpcastLoopError)
+		return
+	}
+	upcastersLock.Lock()
+	defer upcastersLock.Unlock()
+	v := getOrInitVersions(dataType)
+	if _, ok := v.steps[fromVersion]; ok {
+		err = fmt.Errorf("upcaster for %s from %s is already registered", dataType, fromVersion)
+		return
+	}
+	v.steps[fromVersion] = upcastStep{
+		to:     toVersion,
+		upcast: f,
+	}
+	return
+}
+
+// Upcast runs the registered upcasters for md.DataType on data, starting at md.Version.
+// On success md.Version is set to the version the returned data is in.
+func Upcast(md *Metadata, data []byte) (out []byte, err error) {
+	upcastersLock.RLock()
+	defer upcastersLock.RUnlock()
+	out = data
+	v, ok := upcasters[md.DataType]
+	if !ok {
+		return
+	}
+	version := md.Version
+	seen := make(map[string]struct{})
+	for v.current == "" || version != v.current {
+		step, ok := v.steps[version]
+		if !ok {
+			break
+		}
+		if _, ok = seen[version]; ok {
+			err = fmt.Errorf("upcasting %s from %s, error:%w", md.DataType, md.Version, UpcastLoopError)
+			return
+		}
+		seen[version] = struct{}{}
+		out, err = step.upcast(out)
+		if err != nil {
+			err = fmt.Errorf("upcasting %s from %s to %s, error:%w", md.DataType, version, step.to, err)
+			return
+		}
+		version = step.to
+	}
+	if v.strict && v.current != "" && version != v.current {
+		err = fmt.Errorf("data type %s version %s, error:%w", md.DataType, md.Version, UnknownVersionError)
+		return
+	}
+	md.Version = version
+	return
+}
@@ -0,0 +1,563 @@
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/cantara/gober/stream"
+	"github.com/cantara/gober/stream/event"
+	"github.com/cantara/gober/stream/event/store"
+)
+
+var json = jsoniter.ConfigDefault
+
+// Op is the operator of an expression node.
+type Op string
+
+const (
+	OpAnd Op = "and"
+	OpOr  Op = "or"
+	OpNot Op = "not"
+	OpEq  Op = "="
+	OpNe  Op = "!="
+	OpLt  Op = "<"
+	OpLe  Op = "<="
+	OpGt  Op = ">"
+	OpGe  Op = ">="
+	OpIn  Op = "in"
+)
+
+// Fields that can be compared, extra fields are named ExtraPrefix followed by the key in Metadata.Extra.
+// Nested maps in extra are reached by more dots, like extra.customer.id.
+const (
+	FieldStream    = "stream"
+	FieldEventType = "event_type"
+	FieldDataType  = "data_type"
+	FieldVersion   = "version"
+	FieldKey       = "key"
+	FieldCreated   = "created"
+	ExtraPrefix    = "extra."
+)
+
+var SyntaxError = errors.New("invalid filter expression syntax")
+var UnknownOpError = errors.New("unknown filter operator")
+var UnknownFieldError = errors.New("unknown filter field")
+var InvalidValueError = errors.New("invalid filter value")
+
+// Expr is a filter expression over event metadata. Unlike stream.Filter it can be printed, parsed back and serialized
+// to JSON, so the same filter can be used by remote subscribers, CLIs and logs.
+//
+// And and Or have their operands in Args, Not has exactly one. Comparisons have a Field and a Value, which is a
+// string, number, bool or time, and In has a list of values. Times are compared with created and are written as
+// RFC 3339 strings.
+type Expr struct {
+	Op    Op     `json:"op"`
+	Field string `json:"field,omitempty"`
+	Value any    `json:"value,omitempty"`
+	Args  []Expr `json:"args,omitempty"`
+}
+
+// All matches every event.
+func All() Expr {
+	return Expr{Op: OpAnd}
+}
+
+func And(args ...Expr) Expr {
+	return Expr{Op: OpAnd, Args: args}
+}
+
+func Or(args ...Expr) Expr {
+	return Expr{Op: OpOr, Args: args}
+}
+
+func Not(e Expr) Expr {
+	return Expr{Op: OpNot, Args: []Expr{e}}
+}
+
+func Eq(field string, v any) Expr {
+	return Expr{Op: OpEq, Field: field, Value: v}
+}
+
+func Ne(field string, v any) Expr {
+	return Expr{Op: OpNe, Field: field, Value: v}
+}
+
+func Lt(field string, v any) Expr {
+	return Expr{Op: OpLt, Field: field, Value: v}
+}
+
+func Le(field string, v any) Expr {
+	return Expr{Op: OpLe, Field: field, Value: v}
+}
+
+func Gt(field string, v any) Expr {
+	return Expr{Op: OpGt, Field: field, Value: v}
+}
+
+func Ge(field string, v any) Expr {
+	return Expr{Op: OpGe, Field: field, Value: v}
+}
+
+func In(field string, values ...any) Expr {
+	return Expr{Op: OpIn, Field: field, Value: values}
+}
+
+// Between matches events created in the half open range [from, to).
+func Between(from, to time.Time) Expr {
+	return And(Ge(FieldCreated, from), Lt(FieldCreated, to))
+}
+
+// UnmarshalJSON reads both the JSON tree and the text form as a JSON string.
+func (e *Expr) UnmarshalJSON(data []byte) (err error) {
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		err = json.Unmarshal(data, &s)
+		if err != nil {
+			return
+		}
+		*e, err = Parse(s)
+		return
+	}
+	type expr Expr
+	var ex expr
+	err = json.Unmarshal(data, &ex)
+	if err != nil {
+		return
+	}
+	*e = Expr(ex)
+	return
+}
+
+// String returns the text form of the expression, which Parse reads back.
+func (e Expr) String() string {
+	switch e.Op {
+	case OpAnd, OpOr:
+		parts := make([]string, len(e.Args))
+		for i, a := range e.Args {
+			parts[i] = a.operand(e.Op)
+		}
+		return strings.Join(parts, " "+string(e.Op)+" ")
+	case OpNot:
+		if len(e.Args) != 1 {
+			return "not ()"
+		}
+		return "not " + e.Args[0].operand(OpNot)
+	case OpIn:
+		values, _ := e.Value.([]any)
+		parts := make([]string, len(values))
+		for i, v := range values {
+			parts[i] = literal(v)
+		}
+		return fmt.Sprintf("%s in (%s)", e.Field, strings.Join(parts, ", "))
+	}
+	return fmt.Sprintf("%s %s %s", e.Field, e.Op, literal(e.Value))
+}
+
+// operand writes e as an operand of parent, in parentheses when it binds weaker than the parent.
+func (e Expr) operand(parent Op) string {
+	if (e.Op == OpOr && parent != OpOr) || (e.Op == OpAnd && (parent == OpNot || len(e.Args) == 0)) {
+		return "(" + e.String() + ")"
+	}
+	return e.String()
+}
+
+func literal(v any) string {
+	switch v := v.(type) {
+	case string:
+		return strconv.Quote(v)
+	case time.Time:
+		return strconv.Quote(v.Format(time.RFC3339Nano))
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32)
+	case nil:
+		return "null"
+	}
+	return fmt.Sprint(v)
+}
+
+// Matcher reports if an event matches an expression.
+type Matcher func(md event.Metadata) bool
+
+// Filter returns the matcher as a stream.Filter, which returns true for the events to skip.
+func (m Matcher) Filter() stream.Filter {
+	return func(md event.Metadata) bool {
+		return !m(md)
+	}
+}
+
+// Compile validates the expression and compiles it to a Matcher.
+func Compile(e Expr) (m Matcher, err error) {
+	switch e.Op {
+	case OpAnd, OpOr:
+		ms := make([]Matcher, len(e.Args))
+		for i, a := range e.Args {
+			ms[i], err = Compile(a)
+			if err != nil {
+				return
+			}
+		}
+		if e.Op == OpAnd {
+			return func(md event.Metadata) bool {
+				for _, m := range ms {
+					if !m(md) {
+						return false
+					}
+				}
+				return true
+			}, nil
+		}
+		return func(md event.Metadata) bool {
+			for _, m := range ms {
+				if m(md) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	case OpNot:
+		if len(e.Args) != 1 {
+			err = fmt.Errorf("not with %d operands, error:%w", len(e.Args), SyntaxError)
+			return
+		}
+		inner, err := Compile(e.Args[0])
+		if err != nil {
+			return nil, err
+		}
+		return func(md event.Metadata) bool {
+			return !inner(md)
+		}, nil
+	case OpEq, OpNe, OpLt, OpLe, OpGt, OpGe, OpIn:
+		return compileComparison(e)
+	}
+	err = fmt.Errorf("operator %q, error:%w", e.Op, UnknownOpError)
+	return
+}
+
+// MustCompile is Compile that panics on invalid expressions, for expressions known at compile time.
+func MustCompile(e Expr) Matcher {
+	m, err := Compile(e)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// ParseFilter parses and compiles s into a stream.Filter.
+func ParseFilter(s string) (f stream.Filter, err error) {
+	e, err := Parse(s)
+	if err != nil {
+		return
+	}
+	m, err := Compile(e)
+	if err != nil {
+		return
+	}
+	f = m.Filter()
+	return
+}
+
+func compileComparison(e Expr) (m Matcher, err error) {
+	raw := []any{e.Value}
+	if e.Op == OpIn {
+		var ok bool
+		raw, ok = e.Value.([]any)
+		if !ok {
+			err = fmt.Errorf("in on %s needs a list, error:%w", e.Field, InvalidValueError)
+			return
+		}
+	}
+	get, cmp, err := fieldAccess(e.Field)
+	if err != nil {
+		return
+	}
+	values := make([]any, len(raw))
+	for i, v := range raw {
+		values[i], err = normalize(e.Field, v)
+		if err != nil {
+			return
+		}
+	}
+	test := func(c int) bool {
+		switch e.Op {
+		case OpNe:
+			return c != 0
+		case OpLt:
+			return c < 0
+		case OpLe:
+			return c <= 0
+		case OpGt:
+			return c > 0
+		case OpGe:
+			return c >= 0
+		}
+		return c == 0
+	}
+	return func(md event.Metadata) bool {
+		fv, ok := get(md)
+		if !ok {
+			return false
+		}
+		for _, v := range values {
+			c, ok := cmp(fv, v)
+			if !ok {
+				continue
+			}
+			if e.Op != OpEq && e.Op != OpNe && e.Op != OpIn && !ordered(fv) {
+				continue
+			}
+			if test(c) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// fieldAccess returns how to read field from the metadata and how to compare its values.
+// Extra fields that are missing match no comparison, not even !=.
+func fieldAccess(field string) (get func(md event.Metadata) (any, bool), cmp func(a, b any) (int, bool), err error) {
+	switch field {
+	case FieldStream:
+		return func(md event.Metadata) (any, bool) { return md.Stream, true }, compare, nil
+	case FieldEventType:
+		return func(md event.Metadata) (any, bool) { return string(md.EventType), true }, compare, nil
+	case FieldDataType:
+		return func(md event.Metadata) (any, bool) { return md.DataType, true }, compare, nil
+	case FieldKey:
+		return func(md event.Metadata) (any, bool) { return md.Key, true }, compare, nil
+	case FieldVersion:
+		return func(md event.Metadata) (any, bool) { return md.Version, true }, compareVersions, nil
+	case FieldCreated:
+		return func(md event.Metadata) (any, bool) { return md.Created, true }, compare, nil
+	}
+	if !strings.HasPrefix(field, ExtraPrefix) || len(field) == len(ExtraPrefix) {
+		err = fmt.Errorf("field %q, error:%w", field, UnknownFieldError)
+		return
+	}
+	path := strings.Split(strings.TrimPrefix(field, ExtraPrefix), ".")
+	return func(md event.Metadata) (v any, ok bool) {
+		m := md.Extra
+		for i, k := range path {
+			v, ok = m[k]
+			if !ok || i == len(path)-1 {
+				break
+			}
+			m, ok = v.(map[string]any)
+			if !ok {
+				return
+			}
+		}
+		if ok {
+			v, ok = normalizeExtra(v)
+		}
+		return
+	}, compare, nil
+}
+
+// normalize checks that v can be compared with field and converts it to the type used when comparing.
+func normalize(field string, v any) (out any, err error) {
+	switch field {
+	case FieldStream, FieldEventType, FieldDataType, FieldKey, FieldVersion:
+		s, ok := v.(string)
+		if !ok {
+			err = fmt.Errorf("field %s needs a string, got %T, error:%w", field, v, InvalidValueError)
+			return
+		}
+		return s, nil
+	case FieldCreated:
+		switch v := v.(type) {
+		case time.Time:
+			return v, nil
+		case string:
+			t, perr := time.Parse(time.RFC3339Nano, v)
+			if perr != nil {
+				err = fmt.Errorf("field %s needs an RFC 3339 time, got %q, error:%w", field, v, InvalidValueError)
+				return
+			}
+			return t, nil
+		}
+		err = fmt.Errorf("field %s needs a time, got %T, error:%w", field, v, InvalidValueError)
+		return
+	}
+	out, ok := normalizeExtra(v)
+	if !ok {
+		err = fmt.Errorf("field %s needs a string, number or bool, got %T, error:%w", field, v, InvalidValueError)
+	}
+	return
+}
+
+// normalizeExtra converts numbers to float64, as they are after a round trip through JSON.
+func normalizeExtra(v any) (out any, ok bool) {
+	switch v := v.(type) {
+	case string, bool, float64:
+		return v, true
+	case time.Time:
+		return v.Format(time.RFC3339Nano), true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	}
+	return nil, false
+}
+
+func ordered(v any) bool {
+	_, isBool := v.(bool)
+	return !isBool
+}
+
+// compare compares values of the same type, ok is false when the types differ.
+func compare(a, b any) (c int, ok bool) {
+	switch a := a.(type) {
+	case string:
+		b, ok := b.(string)
+		return strings.Compare(a, b), ok
+	case float64:
+		b, ok := b.(float64)
+		if !ok {
+			return 0, false
+		}
+		if a < b {
+			return -1, true
+		} else if a > b {
+			return 1, true
+		}
+		return 0, true
+	case bool:
+		b, ok := b.(bool)
+		if a == b {
+			return 0, ok
+		}
+		return 1, ok
+	case time.Time:
+		b, ok := b.(time.Time)
+		return a.Compare(b), ok
+	}
+	return 0, false
+}
+
+// compareVersions compares dotted versions part by part, numerically where both parts are numbers.
+// A leading v is ignored, so v2 and 2 are the same version.
+func compareVersions(a, b any) (c int, ok bool) {
+	as, ok := a.(string)
+	if !ok {
+		return
+	}
+	bs, ok := b.(string)
+	if !ok {
+		return
+	}
+	ap := strings.Split(strings.TrimPrefix(as, "v"), ".")
+	bp := strings.Split(strings.TrimPrefix(bs, "v"), ".")
+	for i := 0; i < len(ap) || i < len(bp); i++ {
+		if i >= len(ap) {
+			return -1, true
+		}
+		if i >= len(bp) {
+			return 1, true
+		}
+		an, aerr := strconv.ParseUint(ap[i], 10, 64)
+		bn, berr := strconv.ParseUint(bp[i], 10, 64)
+		if aerr == nil && berr == nil {
+			if an != bn {
+				if an < bn {
+					return -1, true
+				}
+				return 1, true
+			}
+			continue
+		}
+		if c = strings.Compare(ap[i], bp[i]); c != 0 {
+			return c, true
+		}
+	}
+	return 0, true
+}
+
+// Selector returns the store selector implied by the expression, so that stores with an index can skip events that
+// can not match. It is only a necessary condition, the compiled filter still has to be applied.
+func (e Expr) Selector() (sel store.Selector) {
+	switch e.Op {
+	case OpAnd:
+		for _, a := range e.Args {
+			as := a.Selector()
+			sel.EventTypes = narrow(sel.EventTypes, as.EventTypes)
+			sel.DataTypes = narrow(sel.DataTypes, as.DataTypes)
+			sel.Keys = narrow(sel.Keys, as.Keys)
+		}
+	case OpEq, OpIn:
+		values := []any{e.Value}
+		if e.Op == OpIn {
+			values, _ = e.Value.([]any)
+		}
+		var strs []string
+		for _, v := range values {
+			s, ok := v.(string)
+			if !ok {
+				return
+			}
+			strs = append(strs, s)
+		}
+		if len(strs) == 0 {
+			return
+		}
+		switch e.Field {
+		case FieldEventType:
+			sel.EventTypes = strs
+		case FieldDataType:
+			sel.DataTypes = strs
+		case FieldKey:
+			sel.Keys = strs
+		}
+	}
+	return
+}
+
+// narrow combines two selections of the same field. When they have no values in common the first is kept, an empty
+// selector field would select everything and the filter rejects the events anyway.
+func narrow(a, b []string) []string {
+	if len(a) == 0 {
+		return b
+	}
+	if len(b) == 0 {
+		return a
+	}
+	var both []string
+	for _, v := range a {
+		for _, w := range b {
+			if v == w {
+				both = append(both, v)
+				break
+			}
+		}
+	}
+	if len(both) == 0 {
+		return a
+	}
+	return both
+}
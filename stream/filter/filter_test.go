@@ -0,0 +1,226 @@
+package filter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cantara/gober/stream"
+	"github.com/cantara/gober/stream/event"
+	"github.com/cantara/gober/stream/event/store"
+	"github.com/cantara/gober/stream/event/store/inmemory"
+)
+
+var created = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+var order = event.Metadata{
+	Stream:    "shop",
+	EventType: event.Created,
+	DataType:  "order",
+	Version:   "1.10.0",
+	Key:       "order-1",
+	Extra: map[string]any{
+		"region": "eu",
+		"amount": 42.0,
+		"paid":   true,
+		"customer": map[string]any{
+			"id": "c1",
+		},
+	},
+	Created: created,
+}
+
+func TestMatch(t *testing.T) {
+	for s, match := range map[string]bool{
+		``:                     true,
+		`data_type = "order"`:  true,
+		`data_type == "order"`: true,
+		`data_type != "order"`: false,
+		`stream = "shop" and event_type = "created"`: true,
+		`event_type = "updated" or key = "order-1"`:  true,
+		`not key = "order-1"`:                        false,
+		`version >= "1.9"`:                           true,
+		`version < "1.2"`:                            false,
+		`extra.region in ("us", "eu")`:               true,
+		`extra.amount > 40 and extra.amount <= 42`:   true,
+		`extra.amount < 1e1`:                         false,
+		`extra.paid = true`:                          true,
+		`extra.paid > false`:                         false,
+		`extra.customer.id = "c1"`:                   true,
+		`extra.missing != "x"`:                       false,
+		`not extra.missing = "x"`:                    true,
+		`extra.region = 1`:                           false,
+		`created >= "2024-05-01T00:00:00Z" and created < "2024-05-02T00:00:00Z"`:          true,
+		`created > "2024-05-01T12:00:00Z"`:                                                false,
+		`(data_type = "invoice" or data_type = "order") and not (key = "a" or key = "b")`: true,
+		`data_type = "invoice" or data_type = "order" and key = "a"`:                      false,
+	} {
+		e, err := Parse(s)
+		if err != nil {
+			t.Errorf("parsing %q: %v", s, err)
+			continue
+		}
+		m, err := Compile(e)
+		if err != nil {
+			t.Errorf("compiling %q: %v", s, err)
+			continue
+		}
+		if m(order) != match {
+			t.Errorf("missmatch %q, %v != %v", s, m(order), match)
+		}
+		if m.Filter()(order) == match {
+			t.Errorf("missmatch filter %q, skipping matching event", s)
+		}
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	for s, expected := range map[string]error{
+		`data_type =`:                  SyntaxError,
+		`data_type "order"`:            SyntaxError,
+		`(data_type = "order"`:         SyntaxError,
+		`data_type = "order" key`:      SyntaxError,
+		`data_type = "order`:           SyntaxError,
+		`data_type ! "order"`:          SyntaxError,
+		`data_type in "order"`:         SyntaxError,
+		`datatype = "order"`:           UnknownFieldError,
+		`extra. = "order"`:             UnknownFieldError,
+		`data_type = 1`:                InvalidValueError,
+		`created > "yesterday"`:        InvalidValueError,
+		`version in ("1", true)`:       InvalidValueError,
+		`extra.region = "eu" or = "x"`: SyntaxError,
+	} {
+		_, err := ParseFilter(s)
+		if !errors.Is(err, expected) {
+			t.Errorf("missmatch error for %q, %v is not %v", s, err, expected)
+		}
+	}
+	_, err := Compile(Expr{Op: "~", Field: FieldKey, Value: "a"})
+	if !errors.Is(err, UnknownOpError) {
+		t.Errorf("missmatch error for unknown operator, %v", err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, e := range []Expr{
+		All(),
+		And(Eq(FieldDataType, "order"), Or(Eq(FieldKey, "a"), Not(Eq(FieldKey, "b")))),
+		Not(And(Eq(FieldStream, "shop"), In("extra.region", "eu", "us"))),
+		Or(And(), Ge("extra.amount", 10.5), Eq("extra.paid", false)),
+		Between(created, created.Add(time.Hour)),
+		Eq(FieldKey, "quote \" and \\ slash"),
+	} {
+		m := MustCompile(e)
+		text := e.String()
+		parsed, err := Parse(text)
+		if err != nil {
+			t.Errorf("parsing %q: %v", text, err)
+			continue
+		}
+		if parsed.String() != text {
+			t.Errorf("missmatch text round trip, %q != %q", parsed.String(), text)
+		}
+		b, err := json.Marshal(e)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		var fromJSON Expr
+		err = json.Unmarshal(b, &fromJSON)
+		if err != nil {
+			t.Errorf("unmarshalling %s: %v", b, err)
+			continue
+		}
+		if fromJSON.String() != text {
+			t.Errorf("missmatch json round trip, %q != %q", fromJSON.String(), text)
+		}
+		if MustCompile(fromJSON)(order) != m(order) || MustCompile(parsed)(order) != m(order) {
+			t.Errorf("missmatch match after round trip of %q", text)
+		}
+	}
+	var e Expr
+	err := json.Unmarshal([]byte(`"data_type = \"order\" and extra.amount > 40"`), &e)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !MustCompile(e)(order) {
+		t.Errorf("missmatch expression read from json string, %s", e)
+	}
+}
+
+func TestSelector(t *testing.T) {
+	sel := MustParse(`event_type = "created" and data_type in ("order", "invoice") and data_type = "order" and extra.region = "eu"`).Selector()
+	if len(sel.EventTypes) != 1 || sel.EventTypes[0] != "created" {
+		t.Errorf("missmatch event types, %v", sel.EventTypes)
+	}
+	if len(sel.DataTypes) != 1 || sel.DataTypes[0] != "order" {
+		t.Errorf("missmatch data types, %v", sel.DataTypes)
+	}
+	if len(sel.Keys) != 0 {
+		t.Errorf("missmatch keys, %v", sel.Keys)
+	}
+	if !MustParse(`data_type = "order" or key = "a"`).Selector().Empty() {
+		t.Error("missmatch, or selected events")
+	}
+	if !MustParse(`not data_type = "order"`).Selector().Empty() {
+		t.Error("missmatch, not selected events")
+	}
+}
+
+func TestStreamFiltered(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	st, err := inmemory.Init("filter", ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s, err := stream.Init[string](st, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for i, dataType := range []string{"order", "invoice", "order", "order"} {
+		_, err = s.Store(event.Event[string]{
+			Type: event.Created,
+			Data: dataType,
+			Metadata: event.Metadata{
+				DataType: dataType,
+				Extra: map[string]any{
+					"n": i,
+				},
+			},
+		})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	e := MustParse(`data_type = "order" and extra.n >= 1`)
+	events, err := s.StreamSelected(e.Selector(), store.STREAM_START, MustCompile(e).Filter(), ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, expected := range []uint64{3, 4} {
+		select {
+		case <-time.After(time.Second):
+			t.Errorf("timed out waiting for event at %d", expected)
+			return
+		case re := <-events:
+			if re.Position != expected {
+				t.Errorf("missmatch position, %d != %d", re.Position, expected)
+			}
+		}
+	}
+	end, err := s.SelectedEnd(e.Selector(), MustCompile(e).Filter())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if end != 4 {
+		t.Errorf("missmatch filtered end, %d != 4", end)
+	}
+}
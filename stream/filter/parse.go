@@ -0,0 +1,299 @@
+package filter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenEnd tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOp
+	tokenOpen
+	tokenClose
+	tokenComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// Parse reads the text form of an expression, like
+//
+//	data_type = "order" and (version >= "2" or extra.region in ("eu", "us")) and not key = "test"
+//
+// Comparisons are field, operator and value, where values are double quoted strings, numbers, true or false.
+// not binds tighter than and, which binds tighter than or. An empty string matches every event.
+func Parse(s string) (e Expr, err error) {
+	tokens, err := lex(s)
+	if err != nil {
+		return
+	}
+	p := parser{tokens: tokens}
+	if p.peek().kind == tokenEnd {
+		return All(), nil
+	}
+	e, err = p.or()
+	if err != nil {
+		return
+	}
+	if t := p.peek(); t.kind != tokenEnd {
+		err = p.unexpected(t)
+	}
+	return
+}
+
+// MustParse is Parse that panics on invalid expressions, for expressions known at compile time.
+func MustParse(s string) Expr {
+	e, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+func lex(s string) (tokens []token, err error) {
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokenOpen, text: "(", pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokenClose, text: ")", pos: i})
+			i++
+		case c == ',':
+			tokens = append(tokens, token{kind: tokenComma, text: ",", pos: i})
+			i++
+		case c == '=' || c == '!' || c == '<' || c == '>':
+			op := s[i : i+1]
+			if i+1 < len(s) && s[i+1] == '=' {
+				op = s[i : i+2]
+			}
+			if op == "!" {
+				err = fmt.Errorf("unexpected %q at %d, error:%w", op, i, SyntaxError)
+				return
+			}
+			tokens = append(tokens, token{kind: tokenOp, text: op, pos: i})
+			i += len(op)
+			if op == "==" {
+				tokens[len(tokens)-1].text = string(OpEq)
+			}
+		case c == '"':
+			end := i + 1
+			for ; end < len(s) && s[end] != '"'; end++ {
+				if s[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(s) {
+				err = fmt.Errorf("unterminated string at %d, error:%w", i, SyntaxError)
+				return
+			}
+			var text string
+			text, err = strconv.Unquote(s[i : end+1])
+			if err != nil {
+				err = fmt.Errorf("string at %d: %v, error:%w", i, err, SyntaxError)
+				return
+			}
+			tokens = append(tokens, token{kind: tokenString, text: text, pos: i})
+			i = end + 1
+		case c == '-' || c == '+' || (c >= '0' && c <= '9'):
+			end := i + 1
+			for ; end < len(s) && strings.IndexByte("0123456789.eE+-", s[end]) >= 0; end++ {
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: s[i:end], pos: i})
+			i = end
+		case c == '_' || unicode.IsLetter(rune(c)):
+			end := i + 1
+			for ; end < len(s) && (s[end] == '_' || s[end] == '.' || s[end] == '-' || unicode.IsLetter(rune(s[end])) || unicode.IsDigit(rune(s[end]))); end++ {
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: s[i:end], pos: i})
+			i = end
+		default:
+			err = fmt.Errorf("unexpected %q at %d, error:%w", c, i, SyntaxError)
+			return
+		}
+	}
+	tokens = append(tokens, token{kind: tokenEnd, pos: len(s)})
+	return
+}
+
+type parser struct {
+	tokens []token
+	i      int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != tokenEnd {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) keyword(word string) bool {
+	t := p.peek()
+	if t.kind == tokenIdent && strings.EqualFold(t.text, word) {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *parser) unexpected(t token) error {
+	if t.kind == tokenEnd {
+		return fmt.Errorf("unexpected end of expression, error:%w", SyntaxError)
+	}
+	return fmt.Errorf("unexpected %q at %d, error:%w", t.text, t.pos, SyntaxError)
+}
+
+func (p *parser) or() (e Expr, err error) {
+	e, err = p.and()
+	if err != nil {
+		return
+	}
+	args := []Expr{e}
+	for p.keyword(string(OpOr)) {
+		e, err = p.and()
+		if err != nil {
+			return
+		}
+		args = append(args, e)
+	}
+	if len(args) > 1 {
+		e = Or(args...)
+	}
+	return
+}
+
+func (p *parser) and() (e Expr, err error) {
+	e, err = p.not()
+	if err != nil {
+		return
+	}
+	args := []Expr{e}
+	for p.keyword(string(OpAnd)) {
+		e, err = p.not()
+		if err != nil {
+			return
+		}
+		args = append(args, e)
+	}
+	if len(args) > 1 {
+		e = And(args...)
+	}
+	return
+}
+
+func (p *parser) not() (e Expr, err error) {
+	if p.keyword(string(OpNot)) {
+		e, err = p.not()
+		if err != nil {
+			return
+		}
+		return Not(e), nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (e Expr, err error) {
+	t := p.next()
+	if t.kind == tokenOpen {
+		if p.peek().kind == tokenClose {
+			p.next()
+			return All(), nil
+		}
+		e, err = p.or()
+		if err != nil {
+			return
+		}
+		if t = p.next(); t.kind != tokenClose {
+			err = p.unexpected(t)
+		}
+		return
+	}
+	if t.kind != tokenIdent {
+		err = p.unexpected(t)
+		return
+	}
+	field := t.text
+	if p.keyword(string(OpIn)) {
+		var values []any
+		values, err = p.list()
+		if err != nil {
+			return
+		}
+		return In(field, values...), nil
+	}
+	op := p.next()
+	if op.kind != tokenOp {
+		err = p.unexpected(op)
+		return
+	}
+	v, err := p.value()
+	if err != nil {
+		return
+	}
+	return Expr{Op: Op(op.text), Field: field, Value: v}, nil
+}
+
+func (p *parser) list() (values []any, err error) {
+	if t := p.next(); t.kind != tokenOpen {
+		err = p.unexpected(t)
+		return
+	}
+	for {
+		var v any
+		v, err = p.value()
+		if err != nil {
+			return
+		}
+		values = append(values, v)
+		t := p.next()
+		if t.kind == tokenClose {
+			return
+		}
+		if t.kind != tokenComma {
+			err = p.unexpected(t)
+			return
+		}
+	}
+}
+
+func (p *parser) value() (v any, err error) {
+	t := p.next()
+	switch t.kind {
+	case tokenString:
+		return t.text, nil
+	case tokenNumber:
+		v, err = strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			err = fmt.Errorf("number %q at %d, error:%w", t.text, t.pos, SyntaxError)
+		}
+		return
+	case tokenIdent:
+		switch strings.ToLower(t.text) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+	}
+	err = p.unexpected(t)
+	return
+}
@@ -14,13 +14,31 @@ type Stream interface {
 	Name() string
 }
 
+// IndexedStream is a Stream that indexes events on type, data type and key, so that selected reads skip events
+// without their metadata being decoded.
+type IndexedStream interface {
+	Stream
+	StreamSelected(sel store.Selector, from store.StreamPosition, ctx context.Context) (out <-chan store.ReadEvent, err error)
+	SelectedEnd(sel store.Selector) (pos uint64, err error)
+}
+
 type FilteredStream[T any] interface {
 	Write() chan<- event.WriteEventReadStatus[T]
 	Store(event event.Event[T]) (position uint64, err error)
 	Stream(eventTypes []event.Type, from store.StreamPosition, filter Filter, ctx context.Context) (out <-chan event.ReadEvent[T], err error)
 	End() (pos uint64, err error)
 	Name() string
+	// Deprecated: FilteredEnd scans the stream on every call, use CachedFilteredEnd or SelectedEnd of SelectedStream.
 	FilteredEnd(eventTypes []event.Type, filter Filter) (pos uint64, err error)
 }
 
+// SelectedStream is a FilteredStream that also reads events by selector and keeps filtered ends up to date.
+// It is kept apart from FilteredStream so implementations of that do not have to change. Init returns one.
+type SelectedStream[T any] interface {
+	FilteredStream[T]
+	StreamSelected(sel store.Selector, from store.StreamPosition, filter Filter, ctx context.Context) (out <-chan event.ReadEvent[T], err error)
+	CachedFilteredEnd(eventTypes []event.Type, filterKey string, filter Filter) (pos uint64, err error)
+	SelectedEnd(sel store.Selector, filter Filter) (pos uint64, err error)
+}
+
 //FilteredStream(eventTypes []event.Type, from store.StreamPosition, filter Filter[MT], cryptKey CryptoKeyProvider, ctx context.Context) (out <-chan event.Event[DT, any], err error)
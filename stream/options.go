@@ -0,0 +1,161 @@
+package stream
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cantara/gober/crypto"
+	"github.com/cantara/gober/stream/event/claimcheck"
+	"github.com/cantara/gober/stream/event/codec"
+	"github.com/cantara/gober/stream/event/compression"
+	"github.com/cantara/gober/stream/event/signature"
+)
+
+type Options struct {
+	Codec                codec.Codec
+	Compressor           compression.Compressor
+	CompressionThreshold int
+	MaxEventSize         int
+	MaxDecompressedSize  int
+	ClaimCheck           claimcheck.BlobStore
+	ClaimCheckThreshold  int
+	KeyRing              crypto.KeyRing
+	Cipher               crypto.Cipher
+	Signer               *signature.Signer
+	TrustStore           signature.TrustStore
+	SignaturePolicy      signature.Policy
+	ErrorHandler         ErrorHandler
+	DeadLetter           Stream
+	Retry                RetryPolicy
+	RequireAAD           bool
+	DecryptKey           CryptoKeyProvider
+}
+
+var EventTooLargeError = errors.New("event is larger than the max event size of the stream")
+
+type Option func(o *Options)
+
+// WithCodec selects the codec used for event data written to the stream, unless the data type has its own codec.
+func WithCodec(c codec.Codec) Option {
+	return func(o *Options) {
+		o.Codec = c
+	}
+}
+
+// WithCompression compresses event data of at least threshold bytes with c. For encrypted streams this is done before
+// encryption. A threshold of 0 or less uses compression.DefaultThreshold.
+func WithCompression(c compression.Compressor, threshold int) Option {
+	return func(o *Options) {
+		if threshold <= 0 {
+			threshold = compression.DefaultThreshold
+		}
+		o.Compressor = c
+		o.CompressionThreshold = threshold
+	}
+}
+
+// WithMaxEventSize rejects writes where the stored data and metadata together are larger than size bytes.
+func WithMaxEventSize(size int) Option {
+	return func(o *Options) {
+		o.MaxEventSize = size
+	}
+}
+
+// WithMaxDecompressedSize limits the size event data is decompressed to when read, so a small stored event can not
+// expand to exhaust memory. Writes of data larger than it are rejected when compressed, so what is written can be read.
+func WithMaxDecompressedSize(size int) Option {
+	return func(o *Options) {
+		o.MaxDecompressedSize = size
+	}
+}
+
+// DecompressLimit returns the size event data is decompressed to at most, the max decompressed size or
+// compression.DefaultMaxSize if it is not set, raised to the max event size if that is larger.
+func (o Options) DecompressLimit() (limit int) {
+	limit = o.MaxDecompressedSize
+	if limit <= 0 {
+		limit = compression.DefaultMaxSize
+	}
+	if o.MaxEventSize > limit {
+		limit = o.MaxEventSize
+	}
+	return
+}
+
+// CheckDecompressedSize returns EventTooLargeError if data of size bytes compressed with the named compressor can not
+// be decompressed within DecompressLimit.
+func (o Options) CheckDecompressedSize(compressor string, size int) error {
+	if compressor == "" || size <= o.DecompressLimit() {
+		return nil
+	}
+	return fmt.Errorf("decompressed size %d over %d, error:%w", size, o.DecompressLimit(), EventTooLargeError)
+}
+
+// WithClaimCheck makes consumers store encrypted payloads larger than threshold bytes in blobs,
+// leaving only a reference in the event. A threshold of 0 or less uses claimcheck.DefaultThreshold.
+func WithClaimCheck(blobs claimcheck.BlobStore, threshold int) Option {
+	return func(o *Options) {
+		if threshold <= 0 {
+			threshold = claimcheck.DefaultThreshold
+		}
+		o.ClaimCheck = blobs
+		o.ClaimCheckThreshold = threshold
+	}
+}
+
+// WithKeyRing makes consumers encrypt with the current key of the key ring and record its key id in the ciphertext,
+// so that data written before a key rotation can still be decrypted. Legacy ciphertexts use the CryptoKeyProvider.
+func WithKeyRing(ring crypto.KeyRing) Option {
+	return func(o *Options) {
+		o.KeyRing = ring
+	}
+}
+
+// WithCipher sets the AEAD consumers encrypt with, the default is crypto.AES256GCM.
+// The cipher is recorded in the ciphertext, so it can be changed without breaking existing events.
+func WithCipher(c crypto.Cipher) Option {
+	return func(o *Options) {
+		o.Cipher = c
+	}
+}
+
+// WithDecryptKey makes consumers decrypt with p instead of their crypto key provider, which is then only used to
+// encrypt. Use it with key stores that create keys when asked for one, like keystore.Local, so reads never create keys.
+func WithDecryptKey(p CryptoKeyProvider) Option {
+	return func(o *Options) {
+		o.DecryptKey = p
+	}
+}
+
+// WithRequireAAD makes consumers reject event data encrypted without associated data, legacy ciphertexts and version 1
+// envelopes, so the data of one event can not be moved to another. Use it once all events are written with associated
+// data, for example after re-encrypting older streams.
+func WithRequireAAD() Option {
+	return func(o *Options) {
+		o.RequireAAD = true
+	}
+}
+
+// WithSigner signs every event written to the stream. The signature covers the id, type, stored data and metadata,
+// so for encrypted streams it is over the ciphertext and can be verified without the encryption key.
+func WithSigner(s *signature.Signer) Option {
+	return func(o *Options) {
+		o.Signer = s
+	}
+}
+
+// WithVerification verifies the signature of every event read from the stream against the trust store,
+// events are delivered or dropped according to the policy and have Verified set if the signature is valid.
+func WithVerification(trust signature.TrustStore, policy signature.Policy) Option {
+	return func(o *Options) {
+		o.TrustStore = trust
+		o.SignaturePolicy = policy
+	}
+}
+
+func NewOptions(opts ...Option) (o Options) {
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return
+}
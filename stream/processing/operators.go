@@ -0,0 +1,203 @@
+package processing
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// pipe runs step for every record of in until the context of in is done. step hands records on with emit.
+func pipe[T, O any](in Flow[T], step func(r Record[T], emit func(Record[O]) bool)) Flow[O] {
+	out := make(chan Record[O])
+	emit := func(r Record[O]) bool {
+		select {
+		case <-in.ctx.Done():
+			return false
+		case out <- r:
+			return true
+		}
+	}
+	go func() {
+		for {
+			select {
+			case <-in.ctx.Done():
+				return
+			case r := <-in.records:
+				step(r, emit)
+			}
+		}
+	}()
+	return Flow[O]{
+		records: out,
+		ctx:     in.ctx,
+	}
+}
+
+// Map transforms the value of every record.
+func Map[T, O any](in Flow[T], f func(v T) O) Flow[O] {
+	return pipe(in, func(r Record[T], emit func(Record[O]) bool) {
+		emit(Record[O]{
+			Value:    f(r.Value),
+			Key:      r.Key,
+			Id:       r.Id,
+			Time:     r.Time,
+			Metadata: r.Metadata,
+			origins:  r.origins,
+		})
+	})
+}
+
+// FlatMap transforms the value of every record into zero or more values. The records get ids derived from the id of
+// the record and the index of the value.
+func FlatMap[T, O any](in Flow[T], f func(v T) []O) Flow[O] {
+	return pipe(in, func(r Record[T], emit func(Record[O]) bool) {
+		values := f(r.Value)
+		for range values {
+			retain(r.origins)
+		}
+		for i, v := range values {
+			emit(Record[O]{
+				Value:    v,
+				Key:      r.Key,
+				Id:       uuid.NewV5(r.Id, strconv.Itoa(i)),
+				Time:     r.Time,
+				Metadata: r.Metadata,
+				origins:  r.origins,
+			})
+		}
+		release(r.origins)
+	})
+}
+
+// Filter keeps the records f returns true for. It gets the whole record, so it can filter on key and metadata.
+func (in Flow[T]) Filter(f func(r Record[T]) bool) Flow[T] {
+	return pipe(in, func(r Record[T], emit func(Record[T]) bool) {
+		if !f(r) {
+			release(r.origins)
+			return
+		}
+		emit(r)
+	})
+}
+
+// KeyBy sets the key of every record to the result of f, the key is used by joins and written hashed with the results.
+func (in Flow[T]) KeyBy(f func(r Record[T]) string) Flow[T] {
+	return pipe(in, func(r Record[T], emit func(Record[T]) bool) {
+		r.Key = f(r)
+		emit(r)
+	})
+}
+
+// Joined is a pair of records with the same key from the two sides of a join.
+type Joined[L, R any] struct {
+	Left  L
+	Right R
+}
+
+// Join pairs every record of left with every record of right with the same key, where the times of the two are at most
+// window apart. Records are kept for the window after the latest time seen on either side, so the checkpoints of the
+// sources do not pass records that can still be joined. The joined records get the time and metadata of the latest of
+// the pair and an id derived from the ids of both.
+func Join[L, R any](left Flow[L], right Flow[R], window time.Duration) Flow[Joined[L, R]] {
+	out := make(chan Record[Joined[L, R]])
+	ctx := left.ctx
+	go func() {
+		lefts := make(map[string][]Record[L])
+		rights := make(map[string][]Record[R])
+		var watermark time.Time
+		emit := func(l Record[L], r Record[R]) bool {
+			j := Record[Joined[L, R]]{
+				Value: Joined[L, R]{
+					Left:  l.Value,
+					Right: r.Value,
+				},
+				Key:      l.Key,
+				Id:       uuid.NewV5(l.Id, r.Id.String()),
+				Time:     l.Time,
+				Metadata: l.Metadata,
+				origins:  append(append([]*origin{}, l.origins...), r.origins...),
+			}
+			if r.Time.After(l.Time) {
+				j.Time = r.Time
+				j.Metadata = r.Metadata
+			}
+			retain(j.origins)
+			select {
+			case <-ctx.Done():
+				return false
+			case out <- j:
+				return true
+			}
+		}
+		advance := func(t time.Time) {
+			if !t.After(watermark) {
+				return
+			}
+			watermark = t
+			lefts = evict(lefts, watermark.Add(-window))
+			rights = evict(rights, watermark.Add(-window))
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-right.ctx.Done():
+				return
+			case l := <-left.records:
+				advance(l.Time)
+				for _, r := range rights[l.Key] {
+					if within(l.Time, r.Time, window) && !emit(l, r) {
+						return
+					}
+				}
+				if l.Time.Before(watermark.Add(-window)) {
+					release(l.origins)
+					continue
+				}
+				lefts[l.Key] = append(lefts[l.Key], l)
+			case r := <-right.records:
+				advance(r.Time)
+				for _, l := range lefts[r.Key] {
+					if within(l.Time, r.Time, window) && !emit(l, r) {
+						return
+					}
+				}
+				if r.Time.Before(watermark.Add(-window)) {
+					release(r.origins)
+					continue
+				}
+				rights[r.Key] = append(rights[r.Key], r)
+			}
+		}
+	}()
+	return Flow[Joined[L, R]]{
+		records: out,
+		ctx:     ctx,
+	}
+}
+
+func within(a, b time.Time, window time.Duration) bool {
+	d := a.Sub(b)
+	return d <= window && d >= -window
+}
+
+// evict drops and releases the buffered records older than before.
+func evict[T any](buffered map[string][]Record[T], before time.Time) map[string][]Record[T] {
+	for k, records := range buffered {
+		kept := records[:0]
+		for _, r := range records {
+			if r.Time.Before(before) {
+				release(r.origins)
+				continue
+			}
+			kept = append(kept, r)
+		}
+		if len(kept) == 0 {
+			delete(buffered, k)
+			continue
+		}
+		buffered[k] = kept
+	}
+	return buffered
+}
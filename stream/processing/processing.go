@@ -0,0 +1,157 @@
+package processing
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/cantara/bragi/sbragi"
+	"github.com/gofrs/uuid"
+
+	"github.com/cantara/gober/stream/checkpoint"
+	"github.com/cantara/gober/stream/consumer"
+	"github.com/cantara/gober/stream/event"
+	"github.com/cantara/gober/stream/event/store"
+)
+
+// Record is a value flowing through a pipeline together with where it came from.
+// Id is derived from the ids of the source events, so processing the same events again gives the same ids.
+type Record[T any] struct {
+	Value    T
+	Key      string
+	Id       uuid.UUID
+	Time     time.Time
+	Metadata event.Metadata
+
+	origins []*origin
+}
+
+// Flow is a typed stream of records, created with From and transformed with the operators of this package.
+// Every flow is read by exactly one operator or sink.
+type Flow[T any] struct {
+	records <-chan Record[T]
+	ctx     context.Context
+}
+
+// origin is a source event that is not completed until every record derived from it is written or dropped.
+type origin struct {
+	source   *source
+	position uint64
+	acc      func()
+	refs     atomic.Int64
+}
+
+func (o *origin) retain() {
+	o.refs.Add(1)
+}
+
+func (o *origin) release() {
+	if o.refs.Add(-1) == 0 {
+		o.source.complete()
+	}
+}
+
+func retain(origins []*origin) {
+	for _, o := range origins {
+		o.retain()
+	}
+}
+
+func release(origins []*origin) {
+	for _, o := range origins {
+		o.release()
+	}
+}
+
+// source tracks the events read from a consumer in order and saves the position up to which all are completed.
+type source struct {
+	name        string
+	checkpoints checkpoint.Store
+	pending     []*origin
+	lock        sync.Mutex
+}
+
+func (s *source) read(position uint64, acc func()) (o *origin) {
+	o = &origin{
+		source:   s,
+		position: position,
+		acc:      acc,
+	}
+	o.refs.Store(1)
+	s.lock.Lock()
+	s.pending = append(s.pending, o)
+	s.lock.Unlock()
+	return
+}
+
+func (s *source) complete() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var completed *origin
+	for len(s.pending) > 0 && s.pending[0].refs.Load() <= 0 {
+		completed = s.pending[0]
+		completed.acc()
+		s.pending = s.pending[1:]
+	}
+	if completed == nil {
+		return
+	}
+	err := s.checkpoints.Save(s.name, completed.position)
+	if err != nil {
+		log.WithError(err).Error("Saving processing checkpoint", "name", s.name, "position", completed.position)
+	}
+}
+
+// From reads every event of c, continuing after the position saved in checkpoints under name. The position is only
+// saved once everything derived from the events up to it is written or dropped, so after a restart events can be
+// processed again, but never skipped. Records are keyed by Metadata.Key and have the time the event was stored.
+func From[T any](name string, c consumer.SelectedConsumer[T], checkpoints checkpoint.Store, ctx context.Context) (f Flow[T], err error) {
+	from, err := checkpoints.Load(name)
+	if err != nil {
+		return
+	}
+	events, err := c.StreamSelected(store.Selector{}, store.StreamPosition(from), nil, ctx)
+	if err != nil {
+		return
+	}
+	s := &source{
+		name:        name,
+		checkpoints: checkpoints,
+	}
+	records := make(chan Record[T])
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e, ok := <-events:
+				if !ok {
+					return
+				}
+				o := s.read(e.Position, e.Acc)
+				if e.Shredded {
+					o.release()
+					continue
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case records <- Record[T]{
+					Value:    e.Data,
+					Key:      e.Metadata.Key,
+					Id:       e.Id,
+					Time:     e.Created,
+					Metadata: e.Metadata,
+					origins:  []*origin{o},
+				}:
+				}
+			}
+		}
+	}()
+	f = Flow[T]{
+		records: records,
+		ctx:     ctx,
+	}
+	return
+}
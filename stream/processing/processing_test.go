@@ -0,0 +1,374 @@
+package processing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/cantara/bragi/sbragi"
+	"github.com/gofrs/uuid"
+
+	"github.com/cantara/gober/crypto"
+	"github.com/cantara/gober/stream"
+	"github.com/cantara/gober/stream/checkpoint"
+	"github.com/cantara/gober/stream/consumer"
+	"github.com/cantara/gober/stream/event"
+	"github.com/cantara/gober/stream/event/store"
+	"github.com/cantara/gober/stream/event/store/inmemory"
+)
+
+var testCryptKey = log.RedactedString("aPSIX6K3yw6cAWDQHGPjmhuOswuRibjyLLnd91ojdK0=")
+
+func cryptKeyProvider(_ string) log.RedactedString {
+	return testCryptKey
+}
+
+type order struct {
+	Id    string   `json:"id"`
+	Items []string `json:"items"`
+}
+
+func writeOrders(t *testing.T, c consumer.Consumer[order], orders ...order) bool {
+	var writes []event.WriteEventReadStatus[order]
+	for _, o := range orders {
+		we := event.NewWriteEvent(event.Event[order]{
+			Type: event.Created,
+			Data: o,
+			Metadata: event.Metadata{
+				DataType: "order",
+				Key:      o.Id,
+			},
+		})
+		c.Write() <- we
+		writes = append(writes, we)
+	}
+	for _, we := range writes {
+		select {
+		case <-time.After(time.Second):
+			t.Error("timed out waiting for the order to be processed")
+			return false
+		case status := <-we.Done():
+			if status.Error != nil {
+				t.Error(status.Error)
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func items(in Flow[order], out stream.Stream) error {
+	return Map(FlatMap(in.Filter(func(r Record[order]) bool {
+		return r.Value.Id != "skip"
+	}), func(o order) []string {
+		return o.Items
+	}), strings.ToUpper).
+		KeyBy(func(r Record[string]) string {
+			return r.Metadata.Key + "/" + r.Value
+		}).
+		To(Sink{
+			Stream:    out,
+			DataType:  "item",
+			CryptoKey: cryptKeyProvider,
+		})
+}
+
+func TestPipeline(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	in, err := inmemory.Init("processing_in", ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	out, err := inmemory.Init("processing_out", ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	c, err := consumer.New[order](in, cryptKeyProvider, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	checkpoints := checkpoint.NewMemory()
+	runCtx, runCancel := context.WithCancel(ctx)
+	defer runCancel()
+	f, err := From("items", c, checkpoints, runCtx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = items(f, out)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !writeOrders(t, c, order{Id: "o1", Items: []string{"a", "b"}}, order{Id: "skip", Items: []string{"c"}}, order{Id: "o2"}, order{Id: "o3", Items: []string{"d"}}) {
+		return
+	}
+	pos, err := checkpoints.Load("items")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if pos != 4 {
+		t.Errorf("missmatch checkpoint, %d != 4", pos)
+	}
+	end, err := out.End()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if end != 3 {
+		t.Errorf("missmatch written records, %d != 3", end)
+	}
+	runCancel()
+
+	err = checkpoints.Save("items", 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	f, err = From("items", c, checkpoints, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = items(f, out)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !writeOrders(t, c, order{Id: "o4", Items: []string{"e"}}) {
+		return
+	}
+	end, err = out.End()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if end != 4 {
+		t.Errorf("missmatch written records after reprocessing, %d != 4", end)
+	}
+
+	results, err := consumer.New[string](out, cryptKeyProvider, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s, err := results.Stream(event.AllTypes(), store.STREAM_START, stream.ReadAll(), ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, expected := range []string{"o1/A", "o1/B", "o3/D", "o4/E"} {
+		e := <-s
+		e.Acc()
+		if e.Metadata.Key != crypto.KeyHash(expected) || e.Data != expected[3:] || e.Metadata.DataType != "item" {
+			t.Errorf("missmatch result, %s %v", expected, e.Event)
+		}
+		if e.Metadata.CausationId == "" {
+			t.Errorf("missmatch result causation, %v", e.Metadata)
+		}
+	}
+}
+
+func TestJoin(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	checkpoints := checkpoint.NewMemory()
+	src := &source{
+		name:        "join",
+		checkpoints: checkpoints,
+	}
+	lefts := make(chan Record[string])
+	rights := make(chan Record[int])
+	joined := Join(Flow[string]{records: lefts, ctx: ctx}, Flow[int]{records: rights, ctx: ctx}, time.Minute)
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	position := uint64(0)
+	record := func(offset time.Duration) (id uuid.UUID, t time.Time, origins []*origin) {
+		position++
+		return uuid.Must(uuid.NewV7()), start.Add(offset), []*origin{src.read(position, func() {})}
+	}
+	results := make(chan Record[Joined[string, int]], 10)
+	go func() {
+		for r := range joined.records {
+			results <- r
+			release(r.origins)
+		}
+	}()
+	for _, in := range []struct {
+		left   bool
+		key    string
+		offset time.Duration
+		value  int
+	}{
+		{true, "a", 0, 1},
+		{false, "a", 30 * time.Second, 2},
+		{false, "b", 40 * time.Second, 3},
+		{true, "a", 3 * time.Minute, 4},
+		{false, "a", 3*time.Minute + 10*time.Second, 5},
+	} {
+		id, tm, origins := record(in.offset)
+		if in.left {
+			lefts <- Record[string]{Value: fmt.Sprint(in.value), Key: in.key, Id: id, Time: tm, origins: origins}
+			continue
+		}
+		rights <- Record[int]{Value: in.value, Key: in.key, Id: id, Time: tm, origins: origins}
+	}
+	for _, expected := range []Joined[string, int]{{"1", 2}, {"4", 5}} {
+		select {
+		case <-time.After(time.Second):
+			t.Errorf("timed out waiting for %v", expected)
+			return
+		case r := <-results:
+			if r.Value != expected {
+				t.Errorf("missmatch joined, %v != %v", r.Value, expected)
+			}
+		}
+	}
+	select {
+	case r := <-results:
+		t.Errorf("missmatch, joined outside window %v", r.Value)
+	case <-time.After(50 * time.Millisecond):
+	}
+	pos, err := checkpoints.Load("join")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if pos != 3 {
+		t.Errorf("missmatch checkpoint, records in the window are not completed, %d != 3", pos)
+	}
+}
+
+type windowInput struct {
+	key    string
+	offset time.Duration
+}
+
+// runWindow feeds records at the offsets through a window, returning the results emitted after each record.
+func runWindow[A any](t *testing.T, windows Windows, lateness time.Duration, agg Aggregator[int, A], inputs []windowInput, check func(i int, state *WindowState[A])) (results []WindowResult[A], checkpoints *checkpoint.Memory) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	checkpoints = checkpoint.NewMemory()
+	src := &source{
+		name:        "window",
+		checkpoints: checkpoints,
+	}
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	in := make(chan Record[int])
+	out, state, err := Window(Flow[int]{records: in, ctx: ctx}.EventTime(func(r Record[int]) time.Time {
+		return start.Add(time.Duration(r.Value) * time.Second)
+	}), "test", windows, lateness, agg)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	emitted := make(chan Record[WindowResult[A]], 10)
+	go func() {
+		for r := range out.records {
+			emitted <- r
+			release(r.origins)
+		}
+	}()
+	for i, input := range inputs {
+		in <- Record[int]{
+			Value:   int(input.offset / time.Second),
+			Key:     input.key,
+			Id:      uuid.Must(uuid.NewV7()),
+			origins: []*origin{src.read(uint64(i+1), func() {})},
+		}
+		time.Sleep(10 * time.Millisecond)
+		if check != nil {
+			check(i, state)
+		}
+	}
+	for {
+		select {
+		case r := <-emitted:
+			results = append(results, r.Value)
+		case <-time.After(50 * time.Millisecond):
+			return
+		}
+	}
+}
+
+func TestTumblingWindow(t *testing.T) {
+	results, checkpoints := runWindow(t, Tumbling(time.Minute), 0, Count[int](), []windowInput{
+		{"a", 0}, {"a", 30 * time.Second}, {"b", 65 * time.Second}, {"a", 70 * time.Second}, {"a", 50 * time.Second}, {"a", 200 * time.Second},
+	}, func(i int, state *WindowState[int]) {
+		if i != 1 {
+			return
+		}
+		open := state.Get("a")
+		if len(open) != 1 || open[0].Count != 2 || open[0].Value != 2 {
+			t.Errorf("missmatch window state, %v", open)
+		}
+	})
+	expected := []struct {
+		key   string
+		start time.Duration
+		count int
+	}{
+		{"a", 0, 2}, {"a", time.Minute, 1}, {"b", time.Minute, 1},
+	}
+	if len(results) != len(expected) {
+		t.Errorf("missmatch results, %v", results)
+		return
+	}
+	for i, e := range expected {
+		if results[i].Key != e.key || results[i].Count != e.count || results[i].Value != e.count || results[i].End.Sub(results[i].Start) != time.Minute || results[i].Start.Second() != 0 || results[i].Start.Minute() != int(e.start/time.Minute) {
+			t.Errorf("missmatch result %d, %v", i, results[i])
+		}
+	}
+	pos, err := checkpoints.Load("window")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if pos != 5 {
+		t.Errorf("missmatch checkpoint, the open window is completed, %d != 5", pos)
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	results, _ := runWindow(t, Sliding(2*time.Minute, time.Minute), 0, Sum(func(v int) float64 { return 1 }), []windowInput{
+		{"a", 10 * time.Second}, {"a", 70 * time.Second}, {"a", 130 * time.Second}, {"a", 10 * time.Minute},
+	}, nil)
+	var counts []int
+	for _, r := range results {
+		counts = append(counts, int(r.Value))
+	}
+	if fmt.Sprint(counts) != "[1 2 2 1]" {
+		t.Errorf("missmatch sliding window counts, %v", counts)
+	}
+}
+
+func TestSessionWindow(t *testing.T) {
+	results, _ := runWindow(t, Session(30*time.Second), 20*time.Second, Count[int](), []windowInput{
+		{"a", 0}, {"a", 45 * time.Second}, {"a", 20 * time.Second}, {"b", 50 * time.Second}, {"a", 5 * time.Minute},
+	}, nil)
+	if len(results) != 2 {
+		t.Errorf("missmatch session results, %v", results)
+		return
+	}
+	if results[0].Key != "a" || results[0].Count != 3 || results[0].End.Sub(results[0].Start) != 75*time.Second {
+		t.Errorf("missmatch merged session, %v", results[0])
+	}
+	if results[1].Key != "b" || results[1].Count != 1 {
+		t.Errorf("missmatch session, %v", results[1])
+	}
+	_, _, err := Window(Flow[int]{}, "invalid", Session(time.Second), 0, Aggregator[int, int]{})
+	if !errors.Is(err, MissingMergeError) {
+		t.Errorf("missmatch error, %v", err)
+	}
+	_, _, err = Window(Flow[int]{}, "invalid", Tumbling(0), 0, Count[int]())
+	if !errors.Is(err, InvalidWindowError) {
+		t.Errorf("missmatch error, %v", err)
+	}
+}
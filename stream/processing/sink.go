@@ -0,0 +1,155 @@
+package processing
+
+import (
+	"context"
+	"time"
+
+	log "github.com/cantara/bragi/sbragi"
+	"github.com/gofrs/uuid"
+
+	"github.com/cantara/gober/crypto"
+	"github.com/cantara/gober/stream"
+	"github.com/cantara/gober/stream/consumer"
+	"github.com/cantara/gober/stream/event"
+	"github.com/cantara/gober/stream/event/store"
+)
+
+// Sink describes the events the records of a flow are written as.
+type Sink struct {
+	Stream    stream.Stream
+	EventType event.Type
+	DataType  string
+	Version   string
+	CryptoKey stream.CryptoKeyProvider
+	Options   []stream.Option
+}
+
+// To writes the records of the flow to the sink stream, encrypted like consumers do, and completes them once written.
+// The written events get ids derived from the record ids and the sink stream name, and events with ids already in the
+// sink stream are not written again, so reprocessing after a restart does not duplicate results.
+// Only the ids in the sink stream when To is called are kept, and each is dropped once its record is seen again, as
+// records are not processed twice by the same flow.
+// Records that fail to be written are retried until the context of the flow is done, so the checkpoints of their sources
+// stop before them and later records are not written ahead of them.
+// Keys set with KeyBy are written hashed with crypto.KeyHash, like the keys of events written by consumers are, so they
+// are not stored in plain text in the metadata. Keys from the metadata of the source events are written as they are.
+func (in Flow[T]) To(sink Sink) (err error) {
+	fs, err := stream.Init[[]byte](sink.Stream, in.ctx, sink.Options...)
+	if err != nil {
+		return
+	}
+	written, err := writtenIds(sink.Stream, in.ctx)
+	if err != nil {
+		return
+	}
+	namespace := uuid.NewV5(uuid.Nil, sink.Stream.Name())
+	eventType := sink.EventType
+	if eventType == "" {
+		eventType = event.Created
+	}
+	go func() {
+		for {
+			select {
+			case <-in.ctx.Done():
+				return
+			case r := <-in.records:
+				id := uuid.NewV5(namespace, r.Id.String())
+				if _, ok := written[id]; ok {
+					log.Debug("Skipping record already written", "id", id, "stream", sink.Stream.Name())
+					delete(written, id)
+					release(r.origins)
+					continue
+				}
+				key := r.Key
+				if key != r.Metadata.Key {
+					key = crypto.KeyHash(key)
+				}
+				e := event.Event[T]{
+					Id:   id,
+					Type: eventType,
+					Data: r.Value,
+					Metadata: event.Metadata{
+						Stream:   sink.Stream.Name(),
+						DataType: sink.DataType,
+						Version:  sink.Version,
+						Key:      key,
+					},
+				}
+				if !writeRecord(fs, sink, e, event.ContextWithEvent(in.ctx, r.Id, r.Metadata), in.ctx) {
+					return
+				}
+				release(r.origins)
+			}
+		}
+	}()
+	return
+}
+
+// writeRecord writes the event to the sink, retrying until it is written or ctx is done.
+func writeRecord[T any](fs stream.FilteredStream[[]byte], sink Sink, e event.Event[T], wctx, ctx context.Context) bool {
+	for {
+		err := write(fs, sink, e, wctx)
+		if err == nil {
+			return true
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+		log.WithError(err).Error("Writing processed record, retrying", "id", e.Id, "stream", sink.Stream.Name())
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func write[T any](fs stream.FilteredStream[[]byte], sink Sink, e event.Event[T], ctx context.Context) (err error) {
+	e.Metadata.ApplyTrace(ctx, e.Id)
+	es, err := consumer.EncryptEvent(&e, sink.CryptoKey, sink.Options...)
+	if err != nil {
+		return
+	}
+	we := event.NewWriteEventWithContext(ctx, es)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case fs.Write() <- we:
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case status := <-we.Done():
+		return status.Error
+	}
+}
+
+// writtenIds reads the ids of the events in s up to its current end.
+func writtenIds(s stream.Stream, ctx context.Context) (ids map[uuid.UUID]struct{}, err error) {
+	ids = make(map[uuid.UUID]struct{})
+	end, err := s.End()
+	if err != nil || end == 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	events, err := s.Stream(store.STREAM_START, ctx)
+	if err != nil {
+		return
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			ids[e.Id] = struct{}{}
+			if e.Position >= end {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package processing
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/cantara/bragi/sbragi"
+	"github.com/gofrs/uuid"
+
+	"github.com/cantara/gober/stream/event"
+)
+
+var InvalidWindowError = errors.New("window size, slide and gap have to be positive")
+var MissingMergeError = errors.New("session windows need an aggregator that can merge")
+
+// Windows decides which windows a record belongs to by its time.
+type Windows struct {
+	size  time.Duration
+	slide time.Duration
+	gap   time.Duration
+}
+
+// Tumbling windows are back to back windows of size, every record is in exactly one.
+func Tumbling(size time.Duration) Windows {
+	return Windows{size: size, slide: size}
+}
+
+// Sliding windows are windows of size starting every slide, a record is in every window covering its time.
+func Sliding(size, slide time.Duration) Windows {
+	return Windows{size: size, slide: slide}
+}
+
+// Session windows group the records of a key that are less than gap apart, a session ends gap after its last record.
+func Session(gap time.Duration) Windows {
+	return Windows{gap: gap}
+}
+
+func (w Windows) valid() bool {
+	if w.gap != 0 {
+		return w.gap > 0
+	}
+	return w.size > 0 && w.slide > 0
+}
+
+// assign returns the windows of a record at t, latest first.
+func (w Windows) assign(t time.Time) (windows []WindowRange) {
+	if w.gap > 0 {
+		return []WindowRange{{Start: t, End: t.Add(w.gap)}}
+	}
+	for start := t.Truncate(w.slide); start.Add(w.size).After(t); start = start.Add(-w.slide) {
+		windows = append(windows, WindowRange{Start: start, End: start.Add(w.size)})
+	}
+	return
+}
+
+// WindowRange is the time range [Start, End) of a window.
+type WindowRange struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Aggregator folds the values of a window into an aggregate. Merge combines the aggregates of two windows and is only
+// needed for session windows, which merge when a record closes the gap between them.
+type Aggregator[T, A any] struct {
+	Init  func() A
+	Add   func(agg A, v T) A
+	Merge func(a, b A) A
+}
+
+// Count counts the records of a window.
+func Count[T any]() Aggregator[T, int] {
+	return Aggregator[T, int]{
+		Init:  func() int { return 0 },
+		Add:   func(agg int, _ T) int { return agg + 1 },
+		Merge: func(a, b int) int { return a + b },
+	}
+}
+
+// Sum sums f of the records of a window.
+func Sum[T any](f func(v T) float64) Aggregator[T, float64] {
+	return Aggregator[T, float64]{
+		Init:  func() float64 { return 0 },
+		Add:   func(agg float64, v T) float64 { return agg + f(v) },
+		Merge: func(a, b float64) float64 { return a + b },
+	}
+}
+
+// WindowResult is the aggregate of the records of a key in a window.
+type WindowResult[A any] struct {
+	WindowRange
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+	Value A      `json:"value"`
+}
+
+type window[A any] struct {
+	WindowRange
+	count    int
+	value    A
+	metadata event.Metadata
+	origins  []*origin
+}
+
+// WindowState is the state of the windows that are not closed yet, for queries while the aggregation runs.
+type WindowState[A any] struct {
+	windows   map[string][]*window[A]
+	watermark time.Time
+	lock      sync.RWMutex
+}
+
+// Get returns the current aggregates of the open windows of key, ordered by start.
+func (s *WindowState[A]) Get(key string) (results []WindowResult[A]) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for _, w := range s.windows[key] {
+		results = append(results, w.result(key))
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Start.Before(results[j].Start)
+	})
+	return
+}
+
+// Keys returns the keys with open windows.
+func (s *WindowState[A]) Keys() (keys []string) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for k := range s.windows {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return
+}
+
+// Watermark returns the latest record time seen, windows close when it passes their end and the allowed lateness.
+func (s *WindowState[A]) Watermark() time.Time {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.watermark
+}
+
+func (w *window[A]) result(key string) WindowResult[A] {
+	return WindowResult[A]{
+		WindowRange: w.WindowRange,
+		Key:         key,
+		Count:       w.count,
+		Value:       w.value,
+	}
+}
+
+// EventTime sets the time of every record to the result of f, the time is used by windows and joins.
+// Records get the time the event was stored by default.
+func (in Flow[T]) EventTime(f func(r Record[T]) time.Time) Flow[T] {
+	return pipe(in, func(r Record[T], emit func(Record[T]) bool) {
+		r.Time = f(r)
+		emit(r)
+	})
+}
+
+// Window aggregates the records per key, set with KeyBy, in windows of their time. A window closes and its result is
+// emitted once a record later than its end plus lateness is seen, records for closed windows are dropped.
+// Windows only close when newer records arrive, so the last windows stay open while the source is idle.
+// The results get ids derived from name, key and window, so reprocessing gives the same ids, and the time of the
+// window end. The sources are not checkpointed past records in open windows.
+func Window[T, A any](in Flow[T], name string, windows Windows, lateness time.Duration, agg Aggregator[T, A]) (out Flow[WindowResult[A]], state *WindowState[A], err error) {
+	if !windows.valid() {
+		err = fmt.Errorf("window %s, error:%w", name, InvalidWindowError)
+		return
+	}
+	if windows.gap > 0 && agg.Merge == nil {
+		err = fmt.Errorf("window %s, error:%w", name, MissingMergeError)
+		return
+	}
+	state = &WindowState[A]{
+		windows: make(map[string][]*window[A]),
+	}
+	namespace := uuid.NewV5(uuid.Nil, name)
+	out = pipe(in, func(r Record[T], emit func(Record[WindowResult[A]]) bool) {
+		for _, w := range addToWindows(state, r, windows, lateness, agg) {
+			res := w.result
+			emit(Record[WindowResult[A]]{
+				Value:    res,
+				Key:      res.Key,
+				Id:       uuid.NewV5(namespace, res.Key+"/"+res.Start.Format(time.RFC3339Nano)+"/"+res.End.Format(time.RFC3339Nano)),
+				Time:     res.End,
+				Metadata: w.metadata,
+				origins:  w.origins,
+			})
+		}
+	})
+	return
+}
+
+type closedWindow[A any] struct {
+	result   WindowResult[A]
+	metadata event.Metadata
+	origins  []*origin
+}
+
+// addToWindows adds the record to its windows and returns the windows that closed, oldest first.
+func addToWindows[T, A any](s *WindowState[A], r Record[T], windows Windows, lateness time.Duration, agg Aggregator[T, A]) (closed []closedWindow[A]) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, wr := range windows.assign(r.Time) {
+		if !wr.End.Add(lateness).After(s.watermark) {
+			log.Debug("Dropping record for closed window", "key", r.Key, "time", r.Time, "watermark", s.watermark)
+			continue
+		}
+		retain(r.origins)
+		if w := find(s.windows[r.Key], wr); w != nil && windows.gap == 0 {
+			w.value = agg.Add(w.value, r.Value)
+			w.count++
+			w.metadata = r.Metadata
+			w.origins = append(w.origins, r.origins...)
+			continue
+		}
+		w := &window[A]{
+			WindowRange: wr,
+			count:       1,
+			value:       agg.Add(agg.Init(), r.Value),
+			metadata:    r.Metadata,
+			origins:     append([]*origin{}, r.origins...),
+		}
+		if windows.gap > 0 {
+			s.windows[r.Key] = mergeSessions(s.windows[r.Key], w, agg.Merge)
+			continue
+		}
+		s.windows[r.Key] = append(s.windows[r.Key], w)
+	}
+	release(r.origins)
+	if r.Time.After(s.watermark) {
+		s.watermark = r.Time
+	}
+	for key, ws := range s.windows {
+		open := ws[:0]
+		for _, w := range ws {
+			if w.End.Add(lateness).After(s.watermark) {
+				open = append(open, w)
+				continue
+			}
+			closed = append(closed, closedWindow[A]{
+				result:   w.result(key),
+				metadata: w.metadata,
+				origins:  w.origins,
+			})
+		}
+		if len(open) == 0 {
+			delete(s.windows, key)
+			continue
+		}
+		s.windows[key] = open
+	}
+	sort.Slice(closed, func(i, j int) bool {
+		if !closed[i].result.End.Equal(closed[j].result.End) {
+			return closed[i].result.End.Before(closed[j].result.End)
+		}
+		return closed[i].result.Key < closed[j].result.Key
+	})
+	return
+}
+
+func find[A any](ws []*window[A], wr WindowRange) *window[A] {
+	for _, w := range ws {
+		if w.Start.Equal(wr.Start) && w.End.Equal(wr.End) {
+			return w
+		}
+	}
+	return nil
+}
+
+// mergeSessions adds the session w to the sessions of a key, merging it with every session it overlaps.
+func mergeSessions[A any](ws []*window[A], w *window[A], merge func(a, b A) A) []*window[A] {
+	kept := ws[:0]
+	for _, o := range ws {
+		if !o.Start.Before(w.End) || !w.Start.Before(o.End) {
+			kept = append(kept, o)
+			continue
+		}
+		w.value = merge(o.value, w.value)
+		w.count += o.count
+		w.origins = append(o.origins, w.origins...)
+		if o.Start.Before(w.Start) {
+			w.Start = o.Start
+		}
+		if o.End.After(w.End) {
+			w.End = o.End
+			w.metadata = o.metadata
+		}
+	}
+	return append(kept, w)
+}
@@ -0,0 +1,34 @@
+package stream
+
+import "time"
+
+// RetryPolicy decides how often and how soon consumers redeliver the events their readers nack.
+type RetryPolicy struct {
+	// Attempts is the number of deliveries before a nacked event is parked, 1 or less parks it on the first nack.
+	Attempts int
+	// Backoff is the delay before the first redelivery, it doubles for every redelivery after it.
+	Backoff time.Duration
+	// MaxBackoff caps the delay, 0 leaves it uncapped.
+	MaxBackoff time.Duration
+}
+
+// Delay returns the delay before redelivering an event that was nacked on delivery attempt.
+func (p RetryPolicy) Delay(attempt int) (d time.Duration) {
+	d = p.Backoff
+	for i := 1; i < attempt && (p.MaxBackoff <= 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return
+}
+
+// WithRetry makes consumers redeliver nacked events by p. Events that are nacked on their last attempt are parked,
+// handed to the error handler and dead-letter stream with the nack error, and acknowledged. Without a retry policy
+// nacked events are parked on the first nack.
+func WithRetry(p RetryPolicy) Option {
+	return func(o *Options) {
+		o.Retry = p
+	}
+}
@@ -0,0 +1,158 @@
+package stream
+
+import (
+	"context"
+	"sync"
+
+	"github.com/cantara/gober/mergedcontext"
+	"github.com/cantara/gober/stream/event"
+	"github.com/cantara/gober/stream/event/store"
+)
+
+type routeKey struct {
+	dataType string
+	version  string
+}
+
+// Routes is the dispatch table of a router. Handlers are registered per data type and optionally version, an event is
+// handled by the handler for its data type and version if there is one, else by the one for its data type.
+type Routes[E any] struct {
+	handlers map[routeKey]func(E)
+	fallback func(E)
+	lock     sync.RWMutex
+}
+
+// Add registers h for events of dataType with version, an empty version is any version without its own handler.
+func (r *Routes[E]) Add(dataType, version string, h func(E)) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.handlers == nil {
+		r.handlers = make(map[routeKey]func(E))
+	}
+	r.handlers[routeKey{dataType: dataType, version: version}] = h
+}
+
+// SetFallback registers h for events no other handler is registered for.
+func (r *Routes[E]) SetFallback(h func(E)) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.fallback = h
+}
+
+// Dispatch calls the handler registered for the data type and version with e, ok is false if there is none.
+func (r *Routes[E]) Dispatch(dataType, version string, e E) (ok bool) {
+	r.lock.RLock()
+	h, ok := r.handlers[routeKey{dataType: dataType, version: version}]
+	if !ok {
+		h, ok = r.handlers[routeKey{dataType: dataType}]
+	}
+	if !ok {
+		h, ok = r.fallback, r.fallback != nil
+	}
+	r.lock.RUnlock()
+	if ok {
+		h(e)
+	}
+	return
+}
+
+// Selector returns the selector for the registered data types, or an empty selector selecting every event when there
+// is a fallback.
+func (r *Routes[E]) Selector() (sel store.Selector) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if r.fallback != nil {
+		return
+	}
+	seen := make(map[string]struct{})
+	for k := range r.handlers {
+		if _, ok := seen[k.dataType]; ok {
+			continue
+		}
+		seen[k.dataType] = struct{}{}
+		sel.DataTypes = append(sel.DataTypes, k.dataType)
+	}
+	return
+}
+
+// Router reads a stream with several data types in one subscription and hands each event to the handler registered
+// for its data type, decoded into the Go type of that handler. Handlers are called one at a time in stream order,
+// so the order of events is kept across data types, and a slow handler delays every handler after it.
+type Router struct {
+	store  Stream
+	routes Routes[storedEvent]
+	opts   Options
+	ctx    context.Context
+}
+
+func NewRouter(st Stream, ctx context.Context, opts ...Option) *Router {
+	return &Router{
+		store: st,
+		opts:  NewOptions(opts...),
+		ctx:   ctx,
+	}
+}
+
+// Route registers h for events of dataType. Events that can not be decoded into a T are reported as read errors.
+func Route[T any](r *Router, dataType string, h func(e event.ReadEvent[T])) {
+	RouteVersion(r, dataType, "", h)
+}
+
+// RouteVersion registers h for events of dataType stored with version, it takes precedence over the handler registered
+// with Route. Routing is on the version the event is stored with, the data is still upcast before it is decoded.
+func RouteVersion[T any](r *Router, dataType, version string, h func(e event.ReadEvent[T])) {
+	r.routes.Add(dataType, version, func(e storedEvent) {
+		re, err := decodeStored[T](e, r.opts)
+		if err != nil {
+			r.opts.ReportReadError(ReadError{Stream: r.store.Name(), Event: e.ReadEvent, Stage: StageData, Err: err}, r.ctx)
+			return
+		}
+		h(re)
+	})
+}
+
+// Fallback registers h for events of data types without a handler, with the data as it is stored.
+// Without a fallback only the registered data types are read.
+func (r *Router) Fallback(h func(e event.ReadEvent[[]byte])) {
+	r.routes.SetFallback(func(e storedEvent) {
+		h(event.ReadEvent[[]byte]{
+			Event: event.Event[[]byte]{
+				Id:       e.Id,
+				Type:     event.TypeFromString(e.Type),
+				Data:     e.Data,
+				Metadata: e.metadata,
+			},
+			Position: e.Position,
+			Created:  e.Created,
+			Verified: e.verified,
+		})
+	})
+}
+
+// Stream starts routing the events of the given types from the position, filter may be nil. Handlers should be
+// registered before, events without a handler when they are read are skipped. Routing stops when ctx is done.
+func (r *Router) Stream(eventTypes []event.Type, from store.StreamPosition, filter Filter, ctx context.Context) (err error) {
+	sel := r.routes.Selector()
+	sel.EventTypes = SelectEventTypes(eventTypes...).EventTypes
+	mctx, cancel := mergedcontext.MergeContexts(r.ctx, ctx)
+	s, err := streamStored(r.store, r.opts, sel, from, filter, mctx)
+	if err != nil {
+		cancel()
+		return
+	}
+	go func() {
+		defer cancel()
+		for {
+			select {
+			case <-mctx.Done():
+				return
+			case e, ok := <-s:
+				if !ok {
+					return
+				}
+				r.routes.Dispatch(e.metadata.DataType, e.metadata.Version, e)
+			}
+		}
+	}()
+	return
+}
@@ -3,13 +3,18 @@ package stream
 import (
 	"context"
 	"fmt"
+	"slices"
 	"time"
 
 	log "github.com/cantara/bragi/sbragi"
 	"github.com/cantara/gober/mergedcontext"
+	"github.com/gofrs/uuid"
 	jsoniter "github.com/json-iterator/go"
 
 	"github.com/cantara/gober/stream/event"
+	"github.com/cantara/gober/stream/event/codec"
+	"github.com/cantara/gober/stream/event/compression"
+	"github.com/cantara/gober/stream/event/signature"
 	"github.com/cantara/gober/stream/event/store"
 )
 
@@ -18,6 +23,8 @@ var json = jsoniter.ConfigDefault
 type eventService[T any] struct {
 	store  Stream
 	writes chan<- event.WriteEventReadStatus[T]
+	ends   *endCache
+	opts   Options
 	ctx    context.Context
 }
 
@@ -43,36 +50,113 @@ func ReadDataType(t string) Filter {
 	return func(md event.Metadata) bool { return md.DataType != t }
 }
 
-func Init[T any](st Stream, ctx context.Context) (out FilteredStream[T], err error) {
+func Init[T any](st Stream, ctx context.Context, opts ...Option) (out SelectedStream[T], err error) {
 	writes := make(chan event.WriteEventReadStatus[T], 0)
 	es := eventService[T]{
 		store:  st,
 		writes: writes,
+		ends:   endCacheFor(st, ctx),
+		opts:   NewOptions(opts...),
 		ctx:    ctx,
 	}
 	out = es
 	go func() {
 		for we := range writes {
 			e := we.Event()
-			if e.Type == event.Invalid {
+			if !e.Type.Valid() {
 				we.Close(store.WriteStatus{
 					Error: fmt.Errorf("event type %s, error:%v", e.Type, event.InvalidTypeError),
 				})
 				continue
 			}
+			if e.Id.IsNil() {
+				id, err := uuid.NewV7()
+				if err != nil {
+					we.Close(store.WriteStatus{
+						Error: err,
+					})
+					continue
+				}
+				e.Id = id
+			}
 			e.Metadata.Stream = es.store.Name()
 			e.Metadata.EventType = e.Type
 			e.Metadata.Created = time.Now()
+			e.Metadata.ApplyTrace(we.Context(), e.Id)
+			if !isRaw[T]() {
+				c, err := codec.Resolve(e.Metadata.Codec, e.Metadata.DataType, es.opts.Codec)
+				if err != nil {
+					we.Close(store.WriteStatus{
+						Error: err,
+					})
+					continue
+				}
+				e.Metadata.Codec = c.Name()
+			}
 			se := we.Store()
 			if se == nil {
 				continue
 			}
+			if !isRaw[T]() {
+				err := es.compress(e, se)
+				if err != nil {
+					we.Close(store.WriteStatus{
+						Error: err,
+					})
+					continue
+				}
+			}
+			if es.opts.Signer != nil {
+				err := es.sign(e, se)
+				if err != nil {
+					we.Close(store.WriteStatus{
+						Error: err,
+					})
+					continue
+				}
+			}
+			if es.opts.MaxEventSize > 0 && len(se.Data)+len(se.Metadata) > es.opts.MaxEventSize {
+				we.Close(store.WriteStatus{
+					Error: fmt.Errorf("event size %d, error:%w", len(se.Data)+len(se.Metadata), EventTooLargeError),
+				})
+				continue
+			}
 			es.store.Write() <- *se
 		}
 	}()
 	return
 }
 
+// compress compresses the stored data and records the compressor in the stored metadata.
+func (es eventService[T]) compress(e *event.Event[T], se *store.WriteEvent) (err error) {
+	data, name, err := compression.Compress(es.opts.Compressor, es.opts.CompressionThreshold, se.Data)
+	if err != nil || name == "" {
+		return
+	}
+	err = es.opts.CheckDecompressedSize(name, len(se.Data))
+	if err != nil {
+		return
+	}
+	e.Metadata.Compression = name
+	md, err := json.Marshal(e.Metadata)
+	if err != nil {
+		return
+	}
+	se.Data = data
+	se.Metadata = md
+	return
+}
+
+// sign signs the stored event and records the signature in the stored metadata.
+func (es eventService[T]) sign(e *event.Event[T], se *store.WriteEvent) (err error) {
+	err = es.opts.Signer.Sign(e.Id, e.Type, se.Data, &e.Metadata)
+	if err != nil {
+		return
+	}
+	se.Metadata, err = json.Marshal(e.Metadata)
+	return
+}
+
 func (es eventService[T]) Write() chan<- event.WriteEventReadStatus[T] {
 	return es.writes
 }
@@ -85,13 +169,14 @@ func (es eventService[T]) Store(e event.Event[T]) (position uint64, err error) {
 }
 
 func (es eventService[T]) Stream(eventTypes []event.Type, from store.StreamPosition, filter Filter, ctx context.Context) (out <-chan event.ReadEvent[T], err error) {
-	filterEventTypes := len(eventTypes) > 0
-	ets := make(map[event.Type]struct{})
-	for _, eventType := range eventTypes {
-		ets[eventType] = struct{}{}
-	}
+	return es.StreamSelected(SelectEventTypes(eventTypes...), from, filter, ctx)
+}
+
+// StreamSelected streams the events matching the selector and not filtered out by filter, which may be nil.
+// Stores implementing IndexedStream evaluate the selector themselves, for other stores it is evaluated on the metadata.
+func (es eventService[T]) StreamSelected(sel store.Selector, from store.StreamPosition, filter Filter, ctx context.Context) (out <-chan event.ReadEvent[T], err error) {
 	mctx, cancel := mergedcontext.MergeContexts(es.ctx, ctx)
-	s, err := es.store.Stream(from, mctx)
+	s, err := streamStored(es.store, es.opts, sel, from, filter, mctx)
 	if err != nil {
 		cancel()
 		return
@@ -105,41 +190,89 @@ func (es eventService[T]) Stream(eventTypes []event.Type, from store.StreamPosit
 			select {
 			case <-mctx.Done():
 				return
-			case e := <-s:
+			case e, ok := <-s:
+				if !ok {
+					return
+				}
+				re, err := decodeStored[T](e, es.opts)
+				if err != nil {
+					es.opts.ReportReadError(ReadError{Stream: es.Name(), Event: e.ReadEvent, Stage: StageData, Err: err}, mctx)
+					continue
+				}
+				select {
+				case <-mctx.Done():
+					return
+				case eventChan <- re:
+				}
+			}
+		}
+	}()
+	return
+}
+
+// storedEvent is an event read from the store that is selected, not filtered out and has its signature accepted.
+type storedEvent struct {
+	store.ReadEvent
+	metadata event.Metadata
+	verified bool
+}
+
+// streamStored streams the events of st matching the selector and filter with their metadata decoded, leaving the data
+// as it is stored. It stops when ctx is done or the store closes its stream, closing out.
+func streamStored(st Stream, opts Options, sel store.Selector, from store.StreamPosition, filter Filter, ctx context.Context) (out <-chan storedEvent, err error) {
+	s, indexed, err := storeStream(st, sel, from, ctx)
+	if err != nil {
+		return
+	}
+	eventChan := make(chan storedEvent, 0)
+	out = eventChan
+	go func() {
+		defer close(eventChan)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e, ok := <-s:
+				if !ok {
+					return
+				}
 				t := event.TypeFromString(e.Type)
 				log.Trace("read event", "type", t)
-				if filterEventTypes {
-					if _, ok := ets[t]; !ok {
-						log.Debug("filtered event", "type", t)
-						continue
-					}
+				if !indexed && !matchEventType(sel, e.Type) {
+					log.Debug("filtered event", "type", t)
+					continue
 				}
 				var metadata event.Metadata
 				err := json.Unmarshal(e.Metadata, &metadata)
 				log.WithError(err).Trace("Unmarshalling event metadata", "event", string(e.Metadata), "metadata", metadata)
 				if err != nil {
+					opts.ReportReadError(ReadError{Stream: st.Name(), Event: e, Stage: StageMetadata, Err: err}, ctx)
 					continue
 				}
-				if filter(metadata) {
-					log.Debug("Filtering metadata", "metadata", metadata)
+				if !indexed && !sel.Match(e.Type, metadata.DataType, metadata.Key) {
 					continue
 				}
-				var d T
-				err = json.Unmarshal(e.Data, &d)
-				log.WithError(err).Trace("Unmarshalling event data", "event", string(e.Data), "data", d)
-				if err != nil {
+				if filter != nil && filter(metadata) {
+					log.Debug("Filtering metadata", "metadata", metadata)
 					continue
 				}
-
-				eventChan <- event.ReadEvent[T]{
-					Event: event.Event[T]{
-						Type:     t,
-						Data:     d,
-						Metadata: metadata,
-					},
-
-					Position: e.Position,
-					Created:  e.Created,
+				verified := false
+				if opts.TrustStore != nil {
+					err = signature.Verify(opts.TrustStore, e.Id, event.Type(e.Type), e.Data, metadata)
+					if !opts.SignaturePolicy.Accept(err) {
+						opts.ReportReadError(ReadError{Stream: st.Name(), Event: e, Stage: StageSignature, Err: err}, ctx)
+						continue
+					}
+					verified = err == nil
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case eventChan <- storedEvent{
+					ReadEvent: e,
+					metadata:  metadata,
+					verified:  verified,
+				}:
 				}
 			}
 		}
@@ -147,6 +280,28 @@ func (es eventService[T]) Stream(eventTypes []event.Type, from store.StreamPosit
 	return
 }
 
+// decodeStored decodes the stored data of e into a T.
+func decodeStored[T any](e storedEvent, opts Options) (out event.ReadEvent[T], err error) {
+	var d T
+	metadata := e.metadata
+	err = unmarshalData(&metadata, e.Data, &d, opts.DecompressLimit())
+	if err != nil {
+		return
+	}
+	out = event.ReadEvent[T]{
+		Event: event.Event[T]{
+			Id:       e.Id,
+			Type:     event.TypeFromString(e.Type),
+			Data:     d,
+			Metadata: metadata,
+		},
+		Position: e.Position,
+		Created:  e.Created,
+		Verified: e.verified,
+	}
+	return
+}
+
 func (es eventService[T]) Name() string {
 	return es.store.Name()
 }
@@ -155,30 +310,54 @@ func (es eventService[T]) End() (pos uint64, err error) {
 	return es.store.End()
 }
 
+// FilteredEnd returns the position of the last event of the event types not filtered out by filter.
+//
+// Deprecated: FilteredEnd scans the stream on every call, as filters can not be told apart to cache their ends.
+// Use CachedFilteredEnd with a key identifying the filter, or SelectedEnd with a nil filter.
 func (es eventService[T]) FilteredEnd(eventTypes []event.Type, filter Filter) (pos uint64, err error) {
-	filterEventTypes := len(eventTypes) > 0
-	ets := make(map[event.Type]struct{})
-	for _, eventType := range eventTypes {
-		ets[eventType] = struct{}{}
+	return es.SelectedEnd(SelectEventTypes(eventTypes...), filter)
+}
+
+// SelectedEnd returns the position of the last event matching the selector and not filtered out by filter.
+// With a nil filter this is answered by the store index, or for stores without one kept up to date like
+// CachedFilteredEnd.
+func (es eventService[T]) SelectedEnd(sel store.Selector, filter Filter) (pos uint64, err error) {
+	is, indexed := es.store.(IndexedStream)
+	if filter == nil {
+		if indexed {
+			return is.SelectedEnd(sel)
+		}
+		return es.cachedEnd(sel, "", nil)
 	}
-	p := uint64(0)
 	end, err := es.End()
 	if err != nil {
 		return
 	}
-	s, err := es.store.Stream(store.STREAM_START, es.ctx)
+	if indexed {
+		end, err = is.SelectedEnd(sel)
+		if err != nil {
+			return
+		}
+	}
+	if end == 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(es.ctx)
+	defer cancel()
+	s, indexed, err := storeStream(es.store, sel, store.STREAM_START, ctx)
 	if err != nil {
 		return
 	}
-	log.WithError(err).Info("got stream end", "end", end, "stream", es.Name())
+	log.Debug("got stream end", "end", end, "stream", es.Name())
+	p := uint64(0)
 	for p < end {
-		e := <-s
+		e, ok := <-s
+		if !ok {
+			return
+		}
 		p = e.Position
-		t := event.TypeFromString(e.Type)
-		if filterEventTypes {
-			if _, ok := ets[t]; !ok {
-				continue
-			}
+		if !indexed && !matchEventType(sel, e.Type) {
+			continue
 		}
 		var metadata event.Metadata
 		err := json.Unmarshal(e.Metadata, &metadata)
@@ -186,10 +365,72 @@ func (es eventService[T]) FilteredEnd(eventTypes []event.Type, filter Filter) (p
 		if err != nil {
 			continue
 		}
-		if filter(metadata) {
+		if !indexed && !sel.Match(e.Type, metadata.DataType, metadata.Key) {
+			continue
+		}
+		if filter != nil && filter(metadata) {
 			continue
 		}
 		pos = p
 	}
 	return
 }
+
+// storeStream streams from the store, using the index if the store has one.
+func storeStream(st Stream, sel store.Selector, from store.StreamPosition, ctx context.Context) (s <-chan store.ReadEvent, indexed bool, err error) {
+	if is, ok := st.(IndexedStream); ok {
+		s, err = is.StreamSelected(sel, from, ctx)
+		return s, true, err
+	}
+	s, err = st.Stream(from, ctx)
+	return
+}
+
+func matchEventType(sel store.Selector, eventType string) bool {
+	return len(sel.EventTypes) == 0 || slices.Contains(sel.EventTypes, eventType)
+}
+
+// SelectEventTypes selects events of any of the types, no types selects all events.
+func SelectEventTypes(eventTypes ...event.Type) (sel store.Selector) {
+	for _, t := range eventTypes {
+		sel.EventTypes = append(sel.EventTypes, string(t))
+	}
+	return
+}
+
+// SelectDataTypes selects events of any of the data types, the indexed alternative to ReadDataType.
+func SelectDataTypes(dataTypes ...string) store.Selector {
+	return store.Selector{
+		DataTypes: dataTypes,
+	}
+}
+
+// unmarshalData decompresses, decodes, upcasts and unmarshals data into d. When T is []byte the data is treated as raw,
+// possibly encrypted, and is left for the layer above to decompress, decode and upcast. Data is decompressed to at most
+// limit bytes.
+func unmarshalData[T any](md *event.Metadata, data []byte, d *T, limit int) (err error) {
+	if isRaw[T]() {
+		return json.Unmarshal(data, d)
+	}
+	c, err := codec.ForName(md.Codec)
+	if err != nil {
+		return
+	}
+	data, err = compression.DecompressLimit(md.Compression, data, limit)
+	if err != nil {
+		return
+	}
+	data, err = event.Upcast(md, data)
+	if err != nil {
+		return
+	}
+	err = c.Unmarshal(data, d)
+	log.WithError(err).Trace("Unmarshalling event data", "codec", c.Name(), "data", d)
+	return
+}
+
+func isRaw[T any]() bool {
+	var t T
+	_, raw := any(t).([]byte)
+	return raw
+}
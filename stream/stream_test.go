@@ -3,20 +3,24 @@ package stream
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"testing"
+	"time"
 
 	"github.com/gofrs/uuid"
 
 	"github.com/cantara/bragi/sbragi"
 	"github.com/cantara/gober/stream/event"
+	"github.com/cantara/gober/stream/event/compression"
+	"github.com/cantara/gober/stream/event/signature"
 	"github.com/cantara/gober/stream/event/store"
 	"github.com/cantara/gober/stream/event/store/inmemory"
 	"github.com/cantara/gober/stream/event/store/ondisk"
 )
 
-var es FilteredStream[[]byte]
+var es SelectedStream[[]byte]
 var ctxGlobal context.Context
 var ctxGlobalCancel context.CancelFunc
 
@@ -208,6 +212,748 @@ func TestStreamDuplicate(t *testing.T) {
 	return
 }
 
+func TestCustomEventType(t *testing.T) {
+	shipped, err := event.RegisterType("shipped")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = es.Store(event.Event[[]byte]{
+		Type: event.Type("not_registered"),
+		Data: []byte("{}"),
+	})
+	if err == nil {
+		t.Error("expected unregistered event type to be rejected")
+		return
+	}
+	pos, err := es.Store(event.Event[[]byte]{
+		Type: shipped,
+		Data: []byte(`{"id":42,"name":"shipped"}`),
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := es.Stream([]event.Type{shipped}, store.STREAM_START, ReadEventType(shipped), ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	e := <-stream
+	if e.Type != shipped || e.Metadata.EventType != shipped {
+		t.Error(fmt.Errorf("missmatch event types, %s != %s", e.Type, shipped))
+		return
+	}
+	if e.Position != pos {
+		t.Error(fmt.Errorf("missmatch event position, %d != %d", e.Position, pos))
+		return
+	}
+	end, err := es.CachedFilteredEnd([]event.Type{shipped}, "all", ReadAll())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if end != pos {
+		t.Error(fmt.Errorf("missmatch filtered end, %d != %d", end, pos))
+		return
+	}
+}
+
+func TestRegisterInvalidEventType(t *testing.T) {
+	for _, name := range []string{"", "Shipped", "invalid", "1st", "with space"} {
+		_, err := event.RegisterType(name)
+		if err == nil {
+			t.Error(fmt.Errorf("expected error registering event type %q", name))
+			return
+		}
+	}
+	ty, err := event.RegisterType(string(event.Created))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if ty != event.Created {
+		t.Error("registering a built-in type should return the built-in type")
+	}
+}
+
+type ddV1 struct {
+	Id       int    `json:"id"`
+	FullName string `json:"full_name"`
+}
+
+func TestUpcastOnRead(t *testing.T) {
+	ctx, cancel := context.WithCancel(ctxGlobal)
+	defer cancel()
+	dataType := "upcast_" + uuid.Must(uuid.NewV7()).String()
+	event.SetCurrentVersion(dataType, "v2", true)
+	err := event.RegisterUpcaster(dataType, "v1", "v2", func(data []byte) ([]byte, error) {
+		var old ddV1
+		err := json.Unmarshal(data, &old)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(dd{
+			Id:   old.Id,
+			Name: old.FullName,
+		})
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	pers, err := inmemory.Init(dataType, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	oldStream, err := Init[ddV1](pers, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	newStream, err := Init[dd](pers, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, v := range []string{"v1", "v0"} {
+		_, err = oldStream.Store(event.Event[ddV1]{
+			Type: event.Created,
+			Data: ddV1{
+				Id:       1,
+				FullName: "old " + v,
+			},
+			Metadata: event.Metadata{
+				DataType: dataType,
+				Version:  v,
+			},
+		})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	_, err = newStream.Store(event.Event[dd]{
+		Type: event.Created,
+		Data: dd{
+			Id:   2,
+			Name: "new",
+		},
+		Metadata: event.Metadata{
+			DataType: dataType,
+			Version:  "v2",
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s, err := newStream.Stream(event.AllTypes(), store.STREAM_START, ReadDataType(dataType), ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	e := <-s
+	if e.Data.Id != 1 || e.Data.Name != "old v1" || e.Metadata.Version != "v2" {
+		t.Error(fmt.Errorf("event was not upcast, %v", e))
+		return
+	}
+	e = <-s
+	if e.Data.Id != 2 || e.Data.Name != "new" {
+		t.Error(fmt.Errorf("expected the unknown version to be skipped, got %v", e))
+		return
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(ctxGlobal)
+	defer cancel()
+	pers, err := inmemory.Init("read_errors_"+uuid.Must(uuid.NewV7()).String(), ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	dl, err := inmemory.Init("read_errors_dead_letter", ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	readErrors := make(chan ReadError, 2)
+	s, err := Init[dd](pers, ctx, WithErrorHandler(func(err ReadError) {
+		readErrors <- err
+	}), WithDeadLetter(dl))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, e := range []store.Event{
+		{
+			Id:       uuid.Must(uuid.NewV7()),
+			Type:     string(event.Created),
+			Data:     []byte(`{"id":1}`),
+			Metadata: []byte(`{"data_type":`),
+		},
+		{
+			Id:       uuid.Must(uuid.NewV7()),
+			Type:     string(event.Created),
+			Data:     []byte(`{"id":"not a number"}`),
+			Metadata: []byte(`{"data_type":"dd","extra":{"origin":"test"}}`),
+		},
+	} {
+		status := make(chan store.WriteStatus, 1)
+		pers.Write() <- store.WriteEvent{
+			Event:  e,
+			Status: status,
+		}
+		if ws := <-status; ws.Error != nil {
+			t.Error(ws.Error)
+			return
+		}
+	}
+	_, err = s.Store(event.Event[dd]{
+		Type: event.Created,
+		Data: dd{
+			Id: 3,
+		},
+		Metadata: event.Metadata{
+			DataType: "dd",
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	events, err := s.Stream(event.AllTypes(), store.STREAM_START, ReadAll(), ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	e := <-events
+	if e.Position != 3 || e.Data.Id != 3 {
+		t.Errorf("missmatch first readable event, %v", e)
+		return
+	}
+	for i, stage := range []ReadStage{StageMetadata, StageData} {
+		re := <-readErrors
+		if re.Stage != stage || re.Event.Position != uint64(i+1) || re.Stream != pers.Name() || re.Err == nil {
+			t.Errorf("missmatch read error, %v", re)
+		}
+	}
+	dead, err := dl.Stream(store.STREAM_START, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for i, stage := range []ReadStage{StageMetadata, StageData} {
+		de := <-dead
+		var metadata event.Metadata
+		err = json.Unmarshal(de.Metadata, &metadata)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if metadata.Extra[DeadLetterStream] != pers.Name() || metadata.Extra[DeadLetterStage] != string(stage) ||
+			metadata.Extra[DeadLetterPosition] != float64(i+1) || metadata.Extra[DeadLetterError] == "" {
+			t.Errorf("missmatch dead-letter metadata, %v", metadata.Extra)
+		}
+		if stage == StageMetadata && metadata.Extra[DeadLetterMetadata] != `{"data_type":` {
+			t.Errorf("missmatch dead-letter original metadata, %v", metadata.Extra[DeadLetterMetadata])
+		}
+		if stage == StageData && (metadata.DataType != "dd" || metadata.Extra["origin"] != "test" || string(de.Data) != `{"id":"not a number"}`) {
+			t.Errorf("missmatch dead-letter event, %v %s", metadata, de.Data)
+		}
+	}
+}
+
+func TestRouter(t *testing.T) {
+	ctx, cancel := context.WithCancel(ctxGlobal)
+	defer cancel()
+	pers, err := inmemory.Init("router_"+uuid.Must(uuid.NewV7()).String(), ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ddStream, err := Init[dd](pers, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	stringStream, err := Init[string](pers, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for i := 0; i < 3; i++ {
+		_, err = ddStream.Store(event.Event[dd]{
+			Type: event.Created,
+			Data: dd{
+				Id: i,
+			},
+			Metadata: event.Metadata{
+				DataType: "dd",
+				Version:  fmt.Sprintf("v%d", i),
+			},
+		})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		for _, dataType := range []string{"string", "unknown"} {
+			_, err = stringStream.Store(event.Event[string]{
+				Type: event.Created,
+				Data: fmt.Sprintf("%s %d", dataType, i),
+				Metadata: event.Metadata{
+					DataType: dataType,
+				},
+			})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}
+	handled := make(chan string, 9)
+	r := NewRouter(pers, ctx)
+	Route(r, "dd", func(e event.ReadEvent[dd]) {
+		handled <- fmt.Sprintf("dd %d", e.Data.Id)
+	})
+	RouteVersion(r, "dd", "v1", func(e event.ReadEvent[dd]) {
+		handled <- fmt.Sprintf("dd v1 %d", e.Data.Id)
+	})
+	Route(r, "string", func(e event.ReadEvent[string]) {
+		handled <- e.Data
+	})
+	r.Fallback(func(e event.ReadEvent[[]byte]) {
+		handled <- "fallback " + e.Metadata.DataType
+	})
+	err = r.Stream(event.AllTypes(), store.STREAM_START, nil, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, expected := range []string{
+		"dd 0", "string 0", "fallback unknown",
+		"dd v1 1", "string 1", "fallback unknown",
+		"dd 2", "string 2", "fallback unknown",
+	} {
+		select {
+		case <-time.After(time.Second):
+			t.Errorf("timed out waiting for %q", expected)
+			return
+		case h := <-handled:
+			if h != expected {
+				t.Errorf("missmatch routed event, %q != %q", h, expected)
+			}
+		}
+	}
+}
+
+func TestCompression(t *testing.T) {
+	ctx, cancel := context.WithCancel(ctxGlobal)
+	defer cancel()
+	pers, err := inmemory.Init(STREAM_NAME+"_compression", ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	cs, err := Init[dd](pers, ctx, WithCompression(compression.Snappy, 1))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = cs.Store(event.Event[dd]{
+		Type: event.Created,
+		Data: dd{
+			Id:   1,
+			Name: "compressed",
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s, err := cs.Stream(event.AllTypes(), store.STREAM_START, ReadAll(), ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	e := <-s
+	if e.Metadata.Compression != compression.SnappyName {
+		t.Error(fmt.Errorf("missmatch event metadata compression, %q != %q", e.Metadata.Compression, compression.SnappyName))
+		return
+	}
+	if e.Data.Id != 1 || e.Data.Name != "compressed" {
+		t.Error(fmt.Errorf("missmatch event data, %v", e.Data))
+	}
+}
+
+func TestMaxEventSize(t *testing.T) {
+	ctx, cancel := context.WithCancel(ctxGlobal)
+	defer cancel()
+	pers, err := inmemory.Init(STREAM_NAME+"_max_size", ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ms, err := Init[dd](pers, ctx, WithMaxEventSize(1024))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = ms.Store(event.Event[dd]{
+		Type: event.Created,
+		Data: dd{
+			Id:   1,
+			Name: "small",
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = ms.Store(event.Event[dd]{
+		Type: event.Created,
+		Data: dd{
+			Id:   2,
+			Name: fmt.Sprintf("%01024d", 0),
+		},
+	})
+	if !errors.Is(err, EventTooLargeError) {
+		t.Error(fmt.Errorf("expected event too large error, got %v", err))
+	}
+}
+
+func TestSignedEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(ctxGlobal)
+	defer cancel()
+	pers, err := inmemory.Init(STREAM_NAME+"_signed", ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	signer, pub, err := signature.GenerateSigner("producer")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	trust := signature.NewLocal()
+	err = trust.Add(signer.KeyId(), pub)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	signed, err := Init[dd](pers, ctx, WithSigner(signer), WithCompression(compression.Zstd, 1))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	unsigned, err := Init[dd](pers, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = signed.Store(event.Event[dd]{
+		Type: event.Created,
+		Data: dd{
+			Id:   1,
+			Name: "signed",
+		},
+		Metadata: event.Metadata{
+			Extra: map[string]any{
+				"count": 3,
+				"name":  "extra",
+			},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = unsigned.Store(event.Event[dd]{
+		Type: event.Created,
+		Data: dd{
+			Id:   2,
+			Name: "unsigned",
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	rejecting, err := Init[dd](pers, ctx, WithVerification(trust, signature.Reject))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	rs, err := rejecting.Stream(event.AllTypes(), store.STREAM_START, ReadAll(), ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	e := <-rs
+	if !e.Verified || e.Data.Name != "signed" {
+		t.Error(fmt.Errorf("expected verified signed event, got %v", e))
+		return
+	}
+	select {
+	case e = <-rs:
+		t.Error(fmt.Errorf("unsigned event delivered with reject policy, %v", e))
+		return
+	case <-time.After(100 * time.Millisecond):
+	}
+	flagging, err := Init[dd](pers, ctx, WithVerification(trust, signature.Flag))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fs, err := flagging.Stream(event.AllTypes(), store.STREAM_START, ReadAll(), ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if e = <-fs; !e.Verified {
+		t.Error(fmt.Errorf("expected verified signed event, got %v", e))
+		return
+	}
+	if e = <-fs; e.Verified || e.Data.Name != "unsigned" {
+		t.Error(fmt.Errorf("expected flagged unsigned event, got %v", e))
+	}
+}
+
+// unindexedStream hides the index of the wrapped store.
+type unindexedStream struct {
+	s Stream
+}
+
+func (u unindexedStream) Write() chan<- store.WriteEvent { return u.s.Write() }
+func (u unindexedStream) Stream(from store.StreamPosition, ctx context.Context) (<-chan store.ReadEvent, error) {
+	return u.s.Stream(from, ctx)
+}
+func (u unindexedStream) End() (uint64, error) { return u.s.End() }
+func (u unindexedStream) Name() string         { return u.s.Name() }
+
+func TestStreamSelected(t *testing.T) {
+	ctx, cancel := context.WithCancel(ctxGlobal)
+	defer cancel()
+	pers, err := inmemory.Init(STREAM_NAME+"_selected", ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, test := range []struct {
+		name string
+		st   Stream
+	}{
+		{"indexed", pers},
+		{"unindexed", unindexedStream{pers}},
+	} {
+		name := test.name
+		s, err := Init[dd](test.st, ctx)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if name == "indexed" {
+			for i, dataType := range []string{"a", "b", "a", "b"} {
+				_, err = s.Store(event.Event[dd]{
+					Type: event.Created,
+					Data: dd{
+						Id:   i,
+						Name: dataType,
+					},
+					Metadata: event.Metadata{
+						DataType: dataType,
+					},
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}
+		end, err := s.SelectedEnd(SelectDataTypes("a"), nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if end != 3 {
+			t.Error(fmt.Errorf("missmatch %s selected end, %d != 3", name, end))
+			return
+		}
+		end, err = s.SelectedEnd(SelectDataTypes("a", "b"), ReadEventType(event.Updated))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if end != 0 {
+			t.Error(fmt.Errorf("missmatch %s filtered selected end, %d != 0", name, end))
+			return
+		}
+		rs, err := s.StreamSelected(SelectDataTypes("b"), store.STREAM_START, nil, ctx)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		for _, expected := range []int{1, 3} {
+			e := <-rs
+			if e.Data.Id != expected || e.Metadata.DataType != "b" {
+				t.Error(fmt.Errorf("missmatch %s selected event, %v", name, e))
+				return
+			}
+		}
+	}
+}
+
+func TestCachedFilteredEnd(t *testing.T) {
+	ctx, cancel := context.WithCancel(ctxGlobal)
+	defer cancel()
+	pers, err := inmemory.Init(STREAM_NAME+"_cached_end", ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s, err := Init[dd](pers, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	store := func(dataType string) (pos uint64) {
+		pos, err := s.Store(event.Event[dd]{
+			Type: event.Created,
+			Metadata: event.Metadata{
+				DataType: dataType,
+			},
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		return pos
+	}
+	end, err := s.CachedFilteredEnd(event.AllTypes(), "a", ReadDataType("a"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if end != 0 {
+		t.Error(fmt.Errorf("missmatch cached end of empty stream, %d != 0", end))
+		return
+	}
+	for _, test := range []struct {
+		dataType string
+		matches  bool
+	}{
+		{"a", true},
+		{"b", false},
+		{"a", true},
+		{"b", false},
+	} {
+		pos := store(test.dataType)
+		if pos == 0 {
+			return
+		}
+		if test.matches {
+			end = pos
+		}
+		cached, err := s.CachedFilteredEnd(event.AllTypes(), "a", ReadDataType("a"))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if cached != end {
+			t.Error(fmt.Errorf("missmatch cached end after writing %s, %d != %d", test.dataType, cached, end))
+			return
+		}
+	}
+	_, err = s.CachedFilteredEnd(event.AllTypes(), "", ReadDataType("a"))
+	if !errors.Is(err, MissingFilterKeyError) {
+		t.Error(fmt.Errorf("missmatch error caching the end of a filter without key, %v", err))
+		return
+	}
+	all, err := s.CachedFilteredEnd(event.AllTypes(), "", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if all != 4 {
+		t.Error(fmt.Errorf("missmatch cached end without filter, %d != 4", all))
+	}
+}
+
+func TestSharedCachedEnd(t *testing.T) {
+	ctx, cancel := context.WithCancel(ctxGlobal)
+	defer cancel()
+	pers, err := inmemory.Init(STREAM_NAME+"_shared_end", ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	first, err := Init[dd](pers, ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	sctx, scancel := context.WithCancel(ctx)
+	defer scancel()
+	second, err := Init[dd](pers, sctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if first.(eventService[dd]).ends != second.(eventService[dd]).ends {
+		t.Error(fmt.Errorf("missmatch, services of the same store do not share the end cache"))
+	}
+	pos, err := first.Store(event.Event[dd]{
+		Type: event.Created,
+		Metadata: event.Metadata{
+			DataType: "a",
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	end, err := second.SelectedEnd(SelectDataTypes("a"), nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if end != pos {
+		t.Error(fmt.Errorf("missmatch shared cached end, %d != %d", end, pos))
+	}
+	scancel()
+	time.Sleep(10 * time.Millisecond)
+	end, err = first.SelectedEnd(SelectDataTypes("a"), nil)
+	if err != nil {
+		t.Error(fmt.Errorf("missmatch error after the service that started the tail stopped, %v", err))
+		return
+	}
+	if end != pos {
+		t.Error(fmt.Errorf("missmatch cached end after restarting the tail, %d != %d", end, pos))
+	}
+	for i := 0; i <= maxCachedEnds; i++ {
+		end, err = first.CachedFilteredEnd(event.AllTypes(), fmt.Sprint(i), ReadDataType("a"))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if end != pos {
+			t.Error(fmt.Errorf("missmatch end %d, %d != %d", i, end, pos))
+			return
+		}
+	}
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+	endCaches.lock.Lock()
+	_, cached := endCaches.caches[pers]
+	endCaches.lock.Unlock()
+	if cached {
+		t.Error("missmatch, end cache kept after all the services of the store stopped")
+	}
+}
+
 func TestTairdown(t *testing.T) {
 	ctxGlobalCancel()
 }